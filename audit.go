@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/regex"
+)
+
+type auditResult struct {
+	Check  string `json:"check"`
+	Status string `json:"status"` // "pass", "warn" or "fail"
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+type auditCheck struct {
+	name string
+	run  func() *auditResult
+}
+
+var auditChecks = []auditCheck{
+	{name: "Firewall", run: auditFirewall},
+	{name: "DNS", run: auditDNS},
+	{name: "SSH", run: auditSSH},
+	{name: "Fail2Ban", run: auditFail2ban},
+	{name: "ClamAV", run: auditClamav},
+	{name: "ClamAV Signatures", run: auditClamavSignatures},
+	{name: "RKhunter", run: auditRkhunter},
+	{name: "Automatic Updates", run: auditUpdates},
+	{name: "MAC", run: auditMAC},
+}
+
+func auditPass(detail string) *auditResult {
+	return &auditResult{Status: "pass", Detail: detail}
+}
+
+func auditWarn(detail string, hint string) *auditResult {
+	return &auditResult{Status: "warn", Detail: detail, Hint: hint}
+}
+
+func auditFail(detail string, hint string) *auditResult {
+	return &auditResult{Status: "fail", Detail: detail, Hint: hint}
+}
+
+func auditFirewall() *auditResult {
+	active := activeFirewalls()
+	if len(active) == 0 {
+		return auditFail("no firewall is enabled", "run --core (Configuring Firewall)")
+	} else if len(active) > 1 {
+		return auditWarn("multiple firewalls are enabled: "+strings.Join(active, ", "), "run --core (Configuring Firewall) to keep only one")
+	}
+
+	policy := ""
+	switch active[0] {
+	case "ufw":
+		if out, err := bash.Run([]string{`ufw`, `status`, `verbose`}, "", nil); err == nil {
+			if m := regexpFind(`Default: (\w+) \(incoming\)`, string(out)); m != "" {
+				policy = m
+			}
+		}
+	case "firewalld":
+		if out, err := bash.Run([]string{`firewall-cmd`, `--get-default-zone`}, "", nil); err == nil {
+			switch strings.TrimSpace(string(out)) {
+			case "drop":
+				policy = "drop"
+			case "public", "block":
+				policy = "deny"
+			default:
+				policy = strings.TrimSpace(string(out)) + " zone"
+			}
+		}
+	case "nftables":
+		if out, err := bash.Run([]string{`nft`, `list`, `chain`, `inet`, `special_modifications`, `input`}, "", nil); err == nil {
+			if m := regexpFind(`policy (\w+);`, string(out)); m != "" {
+				policy = m
+			}
+		}
+	}
+
+	detail := active[0] + " is enabled, incoming: " + policy
+	if policy != "deny" && policy != "drop" && policy != "reject" {
+		return auditWarn(detail, "run --core (Configuring Firewall) to deny incoming connections by default")
+	}
+	return auditPass(detail)
+}
+
+func auditDNS() *auditResult {
+	if serviceActive("stubby") {
+		return auditPass("stubby is forwarding queries over TLS")
+	}
+
+	status := resolvedStatus()
+	if status == "" {
+		return auditFail("systemd-resolved and stubby are not running", "run --core (Securing DNS)")
+	}
+
+	dot := strings.Contains(status, "+DNSOverTLS")
+	dnssec := strings.Contains(status, "DNSSEC=yes")
+
+	if dot && dnssec {
+		return auditPass(status)
+	} else if dot || dnssec {
+		return auditWarn(status, "run --core (Testing DNS) and check "+stateDir+"/dns-check.txt for fallbacks")
+	}
+	return auditFail(status, "run --core (Securing DNS)")
+}
+
+func auditSSH() *auditResult {
+	service := sshService()
+	if !serviceActive(service) && !serviceActive(service+".socket") {
+		return auditPass("sshd is disabled")
+	}
+
+	out, err := bash.Run([]string{`sshd`, `-T`}, "", nil)
+	if err != nil {
+		return auditFail("invalid sshd config: "+strings.TrimSpace(string(out)), "run `sshd -t` and fix the reported errors")
+	}
+
+	conf := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if key, val, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			conf[key] = val
+		}
+	}
+
+	issues := []string{}
+	if conf["permitrootlogin"] == "yes" {
+		issues = append(issues, "root login is allowed")
+	}
+	if conf["passwordauthentication"] == "yes" {
+		issues = append(issues, "password login is allowed")
+	}
+	if conf["x11forwarding"] == "yes" {
+		issues = append(issues, "X11 forwarding is enabled")
+	}
+
+	if len(issues) != 0 {
+		return auditWarn("sshd is running, "+strings.Join(issues, ", "), "run --core and answer yes to hardening SSH")
+	}
+	return auditPass("sshd is running with a hardened config")
+}
+
+func auditFail2ban() *auditResult {
+	if !serviceActive("fail2ban") {
+		return auditFail("fail2ban is not running", "run --core (Installing Fail2Ban)")
+	}
+
+	out, _ := bash.Run([]string{`fail2ban-client`, `status`}, "", nil)
+	jails := strings.TrimSpace(regexpFind(`Jail list:\s*(.*)`, string(out)))
+	if jails == "" {
+		return auditWarn("fail2ban is running without any jails", "run --core (Installing Fail2Ban)")
+	}
+	return auditPass("jails: " + jails)
+}
+
+func auditClamav() *auditResult {
+	daemon, onacc := clamavServices()
+
+	if !serviceActive(daemon) {
+		return auditFail(daemon+" is not running", "run --core (Configuring Clamav)")
+	} else if !serviceActive(onacc) {
+		return auditWarn(onacc+" is not running, files are only checked by scheduled scans", "run --core (Configuring Clamav)")
+	}
+	return auditPass(daemon + " and " + onacc + " are running")
+}
+
+func auditClamavSignatures() *auditResult {
+	var newest time.Time
+	for _, pattern := range []string{"/var/lib/clamav/*.cvd", "/var/lib/clamav/*.cld"} {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			if stat, err := os.Stat(file); err == nil && stat.ModTime().After(newest) {
+				newest = stat.ModTime()
+			}
+		}
+	}
+
+	if newest.IsZero() {
+		return auditFail("no virus signatures found", "run `freshclam`, or --core (Installing Clamav)")
+	}
+
+	days := int(time.Since(newest).Hours() / 24)
+	detail := "updated " + newest.Format("2006-01-02") + " (" + strconv.Itoa(days) + " days ago)"
+
+	if days > 7 {
+		return auditFail(detail, "check that clamav-freshclam is running")
+	} else if days > 2 {
+		return auditWarn(detail, "check that clamav-freshclam is running")
+	}
+	return auditPass(detail)
+}
+
+func auditRkhunter() *auditResult {
+	stat, err := os.Stat(rkhunterReport)
+	if err != nil {
+		if serviceActive(rkhunterUnit + ".timer") {
+			return auditWarn("no check has run yet", "run `systemctl start "+rkhunterUnit+"`")
+		}
+		return auditFail("rkhunter checks are not scheduled", "run --core (Initializing RKhunter)")
+	}
+
+	detail := "last check " + stat.ModTime().Format("2006-01-02")
+	if buf, err := os.ReadFile(rkhunterReport); err == nil && len(strings.TrimSpace(string(buf))) != 0 {
+		warnings := strings.Count(string(buf), "Warning:")
+		return auditWarn(detail+", "+strconv.Itoa(warnings)+" warnings", "review "+rkhunterReport+" and /var/log/rkhunter.log")
+	}
+	return auditPass(detail + ", no warnings")
+}
+
+func auditUpdates() *auditResult {
+	if opts := loadApplied(); opts != nil && opts.value("updatePolicy") == "off" {
+		return auditWarn("automatic updates are off", "run --core and choose an update policy (Configuring Automatic Updates)")
+	}
+
+	if PM == "dnf" {
+		timer := updateTimers()[0]
+		if serviceActive(timer) {
+			return auditPass(timer + " is active")
+		}
+		return auditFail(timer+" is not active", "run --core (Configuring Automatic Updates)")
+	}
+
+	out, _ := bash.Run([]string{`apt-config`, `dump`, `APT::Periodic::Unattended-Upgrade`}, "", nil)
+	if !strings.Contains(string(out), `"1"`) {
+		return auditFail("unattended-upgrades is not enabled", "run --core (Configuring Automatic Updates)")
+	} else if !serviceActive("apt-daily-upgrade.timer") {
+		return auditWarn("unattended-upgrades is enabled, but apt-daily-upgrade.timer is not active", "run `systemctl enable --now apt-daily-upgrade.timer`")
+	}
+	return auditPass("unattended-upgrades is enabled")
+}
+
+func auditMAC() *auditResult {
+	if out, err := bash.Run([]string{`getenforce`}, "", nil); err == nil {
+		mode := strings.TrimSpace(string(out))
+		switch mode {
+		case "Enforcing":
+			return auditPass("SELinux is enforcing")
+		case "Permissive":
+			return auditWarn("SELinux is permissive", "run --core (Configuring SELinux/AppArmor), and `mac` to review denials")
+		default:
+			return auditFail("SELinux is "+strings.ToLower(mode), "run --core (Configuring SELinux/AppArmor)")
+		}
+	}
+
+	if _, err := bash.Run([]string{`aa-enabled`}, "", nil); err == nil {
+		out, _ := bash.Run([]string{`aa-status`, `--complaining`}, "", nil)
+		if n, err := strconv.Atoi(strings.TrimSpace(string(out))); err == nil && n != 0 {
+			return auditWarn("AppArmor is enabled, "+strconv.Itoa(n)+" profiles in complain mode", "run --core (Configuring SELinux/AppArmor)")
+		}
+		return auditPass("AppArmor is enabled")
+	}
+
+	return auditFail("no SELinux or AppArmor found", "install and enable the distro MAC framework")
+}
+
+// regexpFind returns the first capture group of re in str
+func regexpFind(re string, str string) string {
+	if m := regex.Comp(re).RE.FindStringSubmatch(str); len(m) > 1 {
+		return m[1]
+	}
+	return ""
+}
+
+func runAudit() []*auditResult {
+	results := []*auditResult{}
+	for _, check := range auditChecks {
+		res := check.run()
+		res.Check = check.name
+		results = append(results, res)
+	}
+	return results
+}
+
+// auditCLI handles the `status` and `audit [--json]` commands
+func auditCLI() {
+	results := runAudit()
+
+	if cliArgs["json"] == "true" {
+		if buf, err := json.MarshalIndent(results, "", "  "); err == nil {
+			fmt.Println(string(buf))
+		}
+	} else {
+		for _, res := range results {
+			fmt.Printf("%-6s %-18s %s\n", "["+strings.ToUpper(res.Status)+"]", res.Check, res.Detail)
+		}
+
+		hints := false
+		for _, res := range results {
+			if res.Hint != "" {
+				if !hints {
+					fmt.Println("\nRemediation:")
+					hints = true
+				}
+				fmt.Printf("  %s: %s\n", res.Check, res.Hint)
+			}
+		}
+	}
+
+	for _, res := range results {
+		if res.Status == "fail" {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+// number of boots kept in the history
+const bootHistorySize = 30
+
+// number of the slowest units to report
+const bootSlowest = 10
+
+type bootUnit struct {
+	Unit    string  `json:"unit"`
+	Seconds float64 `json:"seconds"`
+}
+
+type bootRecord struct {
+	BootID  string     `json:"bootId"`
+	Date    time.Time  `json:"date"`
+	Total   float64    `json:"total"` // seconds until the default target was reached
+	Slowest []bootUnit `json:"slowest"`
+	Changes []string   `json:"changes,omitempty"` // units disabled or delayed during this boot
+}
+
+type bootSuggestion struct {
+	unit   string
+	action string // "disable", "mask" or "delay"
+	risk   string
+	reason string
+}
+
+// units that only make the boot wait, and can be disabled on most desktops
+var bootWaitUnits = map[string]string{
+	"NetworkManager-wait-online.service":   "Waits for the network before finishing boot, only needed for network mounts and servers",
+	"systemd-networkd-wait-online.service": "Waits for the network before finishing boot, only needed for network mounts and servers",
+	"plymouth-quit-wait.service":           "Waits for the boot splash screen to close",
+	"systemd-udev-settle.service":          "Deprecated, waits for every device to be probed",
+}
+
+// units that can start a couple minutes after boot, instead of during it
+var bootDelayable = map[string]string{
+	"docker.service":        "Container engine, containers with a restart policy start when it does",
+	"containerd.service":    "Container runtime",
+	"snapd.service":         "Snap package daemon",
+	"libvirtd.service":      "Virtual machine manager",
+	"fwupd.service":         "Firmware update daemon",
+	"packagekit.service":    "Package updates for software centers",
+	"clamav-daemon.service": "Virus scanner daemon, loads the whole signature database on start",
+	"clamd@scan.service":    "Virus scanner daemon, loads the whole signature database on start",
+}
+
+// parseSystemdDuration parses a time span printed by systemd-analyze (example: "1min 2.345s", "512ms")
+func parseSystemdDuration(str string) (time.Duration, error) {
+	str = strings.ReplaceAll(strings.TrimSpace(str), "min", "m")
+	return time.ParseDuration(strings.ReplaceAll(str, " ", ""))
+}
+
+// bootTotal returns the seconds it took to reach the default target
+func bootTotal() (float64, error) {
+	out, err := bash.Run([]string{`systemd-analyze`, `time`}, "", nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+
+	line, _, _ := strings.Cut(string(out), "\n")
+	_, total, ok := strings.Cut(line, "= ")
+	if !ok {
+		return 0, fmt.Errorf("unexpected systemd-analyze output: %s", line)
+	}
+
+	d, err := parseSystemdDuration(total)
+	if err != nil {
+		return 0, err
+	}
+	return d.Seconds(), nil
+}
+
+// bootBlame returns the slowest units from systemd-analyze blame
+func bootBlame(n int) []bootUnit {
+	units := []bootUnit{}
+
+	out, err := bash.Run([]string{`systemd-analyze`, `blame`, `--no-pager`}, "", nil)
+	if err != nil {
+		return units
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// the unit is the last field, everything before it is the time span
+		d, err := parseSystemdDuration(strings.Join(fields[:len(fields)-1], " "))
+		if err != nil {
+			continue
+		}
+
+		units = append(units, bootUnit{Unit: fields[len(fields)-1], Seconds: d.Seconds()})
+		if len(units) >= n {
+			break
+		}
+	}
+
+	return units
+}
+
+func loadBootHistory() []*bootRecord {
+	history := []*bootRecord{}
+	if buf, err := os.ReadFile(stateDir + "/boot-history.json"); err == nil {
+		json.Unmarshal(buf, &history)
+	}
+	return history
+}
+
+func saveBootHistory(history []*bootRecord) {
+	if len(history) > bootHistorySize {
+		history = history[len(history)-bootHistorySize:]
+	}
+
+	if buf, err := json.MarshalIndent(history, "", "  "); err == nil {
+		os.MkdirAll(stateDir, 0700)
+		os.WriteFile(stateDir+"/boot-history.json", buf, 0644)
+	}
+}
+
+// recordBoot adds the current boot to the history, or updates it if it was already recorded
+func recordBoot(history []*bootRecord) ([]*bootRecord, *bootRecord, error) {
+	total, err := bootTotal()
+	if err != nil {
+		return history, nil, err
+	}
+
+	bootID := ""
+	if buf, err := os.ReadFile("/proc/sys/kernel/random/boot_id"); err == nil {
+		bootID = strings.TrimSpace(string(buf))
+	}
+
+	record := &bootRecord{BootID: bootID, Date: time.Now(), Total: total, Slowest: bootBlame(bootSlowest)}
+
+	if len(history) != 0 && history[len(history)-1].BootID == bootID {
+		record.Changes = history[len(history)-1].Changes
+		history[len(history)-1] = record
+	} else {
+		history = append(history, record)
+	}
+
+	return history, record, nil
+}
+
+// bootSuggestions cross references the slowest units with the debloat catalog and the known slow units
+func bootSuggestions(record *bootRecord) []bootSuggestion {
+	suggestions := []bootSuggestion{}
+
+	for _, unit := range record.Slowest {
+		if !serviceEnabled(unit.Unit) {
+			continue
+		}
+
+		found := false
+		for _, item := range debloatCatalog {
+			if item.Action == "remove" || !goutil.Contains(item.Services, unit.Unit) || (item.PM != "" && item.PM != PM) {
+				continue
+			}
+
+			found = true
+			if item.blocker() == "" {
+				suggestions = append(suggestions, bootSuggestion{unit: unit.Unit, action: item.Action, risk: item.Risk, reason: item.Rationale})
+			}
+			break
+		}
+		if found {
+			continue
+		}
+
+		if reason, ok := bootWaitUnits[unit.Unit]; ok {
+			suggestions = append(suggestions, bootSuggestion{unit: unit.Unit, action: "disable", risk: "medium", reason: reason})
+		} else if reason, ok := bootDelayable[unit.Unit]; ok {
+			suggestions = append(suggestions, bootSuggestion{unit: unit.Unit, action: "delay", risk: "low", reason: reason})
+		}
+	}
+
+	return suggestions
+}
+
+// serviceEnabled returns true if a unit starts on boot
+func serviceEnabled(unit string) bool {
+	out, _ := bash.Run([]string{`systemctl`, `is-enabled`, unit}, "", nil)
+	state := strings.TrimSpace(string(out))
+	return state == "enabled" || state == "enabled-runtime"
+}
+
+// delayUnit starts a unit from a timer a couple minutes after boot, instead of during it
+func delayUnit(unit string) error {
+	name := "special-modifications-delay-" + strings.TrimSuffix(strings.ReplaceAll(unit, "@", "-"), ".service")
+
+	timer := `[Unit]
+Description=Delayed start of ` + unit + `
+
+[Timer]
+OnBootSec=2min
+Unit=` + unit + `
+
+[Install]
+WantedBy=timers.target
+`
+
+	if err := os.WriteFile("/etc/systemd/system/"+name+".timer", []byte(timer), 0644); err != nil {
+		return err
+	}
+
+	bash.Run([]string{`systemctl`, `daemon-reload`}, "", nil)
+	if out, err := bash.Run([]string{`systemctl`, `enable`, name + ".timer"}, "", nil); err != nil {
+		return fmt.Errorf("failed to enable timer: %s", strings.TrimSpace(string(out)))
+	}
+	bash.Run([]string{`systemctl`, `disable`, unit}, "", nil)
+
+	return nil
+}
+
+func printBootHistory(history []*bootRecord) {
+	fmt.Println("Boot history:")
+	for i, record := range history {
+		line := "  " + record.Date.Format("2006-01-02 15:04") + "  " + strconv.FormatFloat(record.Total, 'f', 1, 64) + "s"
+		if i != 0 {
+			diff := record.Total - history[i-1].Total
+			if diff >= 0 {
+				line += "  (+" + strconv.FormatFloat(diff, 'f', 1, 64) + "s)"
+			} else {
+				line += "  (" + strconv.FormatFloat(diff, 'f', 1, 64) + "s)"
+			}
+		}
+		if len(record.Changes) != 0 {
+			line += "  changed: " + strings.Join(record.Changes, ", ")
+		}
+		fmt.Println(line)
+	}
+}
+
+// bootConfig asks up front what to do with the suggestions, for when the boot step runs after a reboot
+//
+// "report" only shows them, "low-risk" applies the low risk ones, and "all" applies every suggestion
+func bootConfig(opts *config) {
+	opts.addSelect("bootPolicy", "After rebooting, what should be done with the slow boot units that are found?", "report", "low-risk", "all")
+}
+
+// optimizeBoot reports the boot time, and offers to disable or delay the slow units it knows about
+//
+// the suggestions are applied by the bootPolicy config if it was set, or asked for one at a time
+func optimizeBoot(opts *config) {
+	// after a reboot this runs from the resume service, which can start before the boot has finished
+	bash.Run([]string{`timeout`, `600`, `systemctl`, `is-system-running`, `--wait`}, "", nil)
+
+	history, record, err := recordBoot(loadBootHistory())
+	if err != nil {
+		fmt.Println("Failed to analyze boot:", err)
+		return
+	}
+
+	fmt.Println("Boot time: " + strconv.FormatFloat(record.Total, 'f', 1, 64) + "s")
+
+	suggestions := bootSuggestions(record)
+	suggested := map[string]bool{}
+	for _, suggestion := range suggestions {
+		suggested[suggestion.unit] = true
+	}
+
+	fmt.Println("Slowest units:")
+	for _, unit := range record.Slowest {
+		line := fmt.Sprintf("  %6.2fs  %s", unit.Seconds, unit.Unit)
+		if suggested[unit.Unit] {
+			line += "  *"
+		}
+		fmt.Println(line)
+	}
+
+	if out, err := bash.Run([]string{`systemd-analyze`, `critical-chain`, `--no-pager`}, "", nil); err == nil {
+		fmt.Println("\nCritical chain:")
+		fmt.Println(strings.TrimRight(string(out), "\n"))
+	}
+
+	fmt.Println("")
+	printBootHistory(history)
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].risk == "low" && suggestions[j].risk != "low"
+	})
+
+	for _, suggestion := range suggestions {
+		fmt.Println("\n" + suggestion.unit + " (" + suggestion.risk + " risk): " + suggestion.reason)
+
+		// changes to boot are never made without asking
+		switch opts.value("bootPolicy") {
+		case "report":
+			continue
+		case "low-risk":
+			if suggestion.risk != "low" {
+				continue
+			}
+		case "all":
+		default:
+			if !opts.addBool("boot-"+suggestion.unit, strings.ToUpper(suggestion.action[:1])+suggestion.action[1:]+" "+suggestion.unit+"?", false) {
+				continue
+			}
+		}
+
+		var err error
+		switch suggestion.action {
+		case "delay":
+			err = delayUnit(suggestion.unit)
+		case "mask":
+			_, err = bash.Run([]string{`systemctl`, `mask`, suggestion.unit}, "", nil)
+		default:
+			_, err = bash.Run([]string{`systemctl`, `disable`, suggestion.unit}, "", nil)
+		}
+
+		if err != nil {
+			fmt.Println("Failed to "+suggestion.action+" "+suggestion.unit+":", err)
+			continue
+		}
+		record.Changes = append(record.Changes, suggestion.action+" "+suggestion.unit)
+	}
+
+	saveBootHistory(history)
+
+	if len(record.Changes) != 0 {
+		fmt.Println("\nReboot and run `boot` again to compare the boot time")
+	}
+}
+
+// bootCLI handles the `boot` command
+func bootCLI() {
+	optimizeBoot(newConfig())
+}
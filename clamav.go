@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+// scanExcludes are the default directories (regex) skipped by virus scans
+var scanExcludes = []string{
+	"^/proc",
+	"^/sys",
+	"^/dev",
+	"^/run",
+	"/\\.clamtk/viruses",
+	"/\\.gvfs",
+	"/gvfs",
+	"smb4k",
+	"/\\.thunderbird",
+	"/\\.mozilla-thunderbird",
+	"/\\.evolution",
+	"/Mail",
+	"/kmail",
+}
+
+type scanSchedule struct {
+	Name     string   `json:"name"`
+	Calendar string   `json:"calendar"` // systemd OnCalendar format
+	Paths    []string `json:"paths"`
+	Exclude  []string `json:"exclude"`
+	Nice     int      `json:"nice"`
+	Log      string   `json:"log"`
+}
+
+func clamavConfig(opts *config) {
+	opts.addValue("scanSchedule", "When should the scheduled virus scan run (default: *-*-* 02:00:00)?", "*-*-* 02:00:00")
+	opts.addValue("scanPaths", "Which paths should the scheduled virus scan check (default: /)?", "/")
+	opts.addValue("quarantineDir", "Where should infected files be quarantined (default: "+defaultQuarantineDir+")?", defaultQuarantineDir)
+}
+
+func newScanSchedule(name string) *scanSchedule {
+	return &scanSchedule{
+		Name:     name,
+		Calendar: "*-*-* 02:00:00",
+		Paths:    []string{"/"},
+		Exclude:  append([]string{}, scanExcludes...),
+		Nice:     15,
+		Log:      "/var/log/clamav/scan-" + name + ".log",
+	}
+}
+
+func (schedule *scanSchedule) unit() string {
+	return "clamav-scan-" + schedule.Name
+}
+
+// install writes the systemd service and timer for the schedule
+func (schedule *scanSchedule) install() error {
+	clamscan := "/usr/bin/clamscan"
+	if out, err := bash.Run([]string{`which`, `clamscan`}, "", nil); err == nil && len(out) != 0 {
+		clamscan = strings.TrimSpace(string(out))
+	}
+
+	quarantine := quarantinePath()
+
+	args := []string{clamscan, `-r`, `--infected`, `--move=` + quarantine, `--log=` + schedule.Log, `--exclude-dir=^` + regexp.QuoteMeta(quarantine)}
+	for _, dir := range schedule.Exclude {
+		args = append(args, `--exclude-dir=`+dir)
+	}
+	args = append(args, schedule.Paths...)
+
+	for i, arg := range args {
+		args[i] = strconv.Quote(arg)
+	}
+
+	service := `[Unit]
+Description=ClamAV scheduled scan (` + schedule.Name + `)
+After=clamav-freshclam.service
+
+[Service]
+Type=oneshot
+Nice=` + strconv.Itoa(schedule.Nice) + `
+IOSchedulingClass=idle
+CPUSchedulingPolicy=idle
+# clamscan exits with 1 when a virus was found
+SuccessExitStatus=1
+ExecStart=` + strings.Join(args, " ") + `
+`
+
+	timer := `[Unit]
+Description=ClamAV scheduled scan (` + schedule.Name + `)
+
+[Timer]
+OnCalendar=` + schedule.Calendar + `
+Persistent=true
+RandomizedDelaySec=15m
+
+[Install]
+WantedBy=timers.target
+`
+
+	if out, err := bash.Run([]string{`systemd-analyze`, `calendar`, schedule.Calendar}, "", nil); err != nil {
+		return fmt.Errorf("invalid schedule %q: %s", schedule.Calendar, strings.TrimSpace(string(out)))
+	}
+
+	os.MkdirAll(quarantine, 0700)
+	os.MkdirAll("/var/log/clamav", 0755)
+
+	if err := os.WriteFile("/etc/systemd/system/"+schedule.unit()+".service", []byte(service), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile("/etc/systemd/system/"+schedule.unit()+".timer", []byte(timer), 0644); err != nil {
+		return err
+	}
+
+	bash.Run([]string{`systemctl`, `daemon-reload`}, "", nil)
+	if out, err := bash.Run([]string{`systemctl`, `enable`, `--now`, schedule.unit() + ".timer"}, "", nil); err != nil {
+		return fmt.Errorf("failed to enable timer: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (schedule *scanSchedule) remove() {
+	bash.Run([]string{`systemctl`, `disable`, `--now`, schedule.unit() + ".timer"}, "", nil)
+	os.Remove("/etc/systemd/system/" + schedule.unit() + ".service")
+	os.Remove("/etc/systemd/system/" + schedule.unit() + ".timer")
+	bash.Run([]string{`systemctl`, `daemon-reload`}, "", nil)
+}
+
+func loadScanSchedules() []*scanSchedule {
+	schedules := []*scanSchedule{}
+	if buf, err := os.ReadFile(stateDir + "/scan-schedules.json"); err == nil {
+		json.Unmarshal(buf, &schedules)
+	}
+	return schedules
+}
+
+func saveScanSchedules(schedules []*scanSchedule) {
+	if buf, err := json.MarshalIndent(schedules, "", "  "); err == nil {
+		os.MkdirAll(stateDir, 0700)
+		os.WriteFile(stateDir+"/scan-schedules.json", buf, 0644)
+	}
+}
+
+// setScanSchedule installs a schedule, replacing any existing schedule with the same name
+func setScanSchedule(schedule *scanSchedule) error {
+	if err := schedule.install(); err != nil {
+		return err
+	}
+
+	schedules := []*scanSchedule{}
+	for _, s := range loadScanSchedules() {
+		if s.Name != schedule.Name {
+			schedules = append(schedules, s)
+		}
+	}
+	saveScanSchedules(append(schedules, schedule))
+	return nil
+}
+
+// scheduleScans replaces the old root crontab scan with a systemd timer
+func scheduleScans(opts *config) {
+	bash.RunRaw(`if crontab -l 2>/dev/null | grep -q "# clamav-scan"; then crontab -l | grep -v "# clamav-scan" | crontab -; fi`, "", nil)
+
+	schedule := newScanSchedule("daily")
+	for _, s := range loadScanSchedules() {
+		if s.Name == schedule.Name {
+			schedule = s
+		}
+	}
+
+	if val := opts.value("scanSchedule"); val != "" {
+		schedule.Calendar = val
+	}
+	if paths := strings.Fields(opts.value("scanPaths")); len(paths) != 0 {
+		schedule.Paths = paths
+	}
+
+	if err := setScanSchedule(schedule); err != nil {
+		fmt.Println("Failed to schedule virus scan:", err)
+	}
+}
+
+// scanScheduleCLI handles the `scan-schedule [list|set|remove]` command
+func scanScheduleCLI() {
+	action := cliArgs["1"]
+	name := cliArgs["name"]
+	if name == "" {
+		name = "daily"
+	}
+
+	if !regex.Comp(`^[A-Za-z0-9_-]+$`).Match([]byte(name)) {
+		fmt.Println("Invalid schedule name: " + name)
+		return
+	}
+
+	switch action {
+	case "set", "add":
+		schedule := newScanSchedule(name)
+		for _, s := range loadScanSchedules() {
+			if s.Name == name {
+				schedule = s
+			}
+		}
+
+		if val := cliArgs["on"]; val != "" {
+			schedule.Calendar = val
+		}
+		if val := cliArgs["paths"]; val != "" {
+			schedule.Paths = strings.Fields(strings.ReplaceAll(val, ",", " "))
+		}
+		if val := cliArgs["exclude"]; val != "" {
+			schedule.Exclude = append(schedule.Exclude, strings.Split(val, ",")...)
+		}
+		if val, err := strconv.Atoi(cliArgs["nice"]); err == nil && val >= -20 && val <= 19 {
+			schedule.Nice = val
+		}
+		if val := cliArgs["log"]; val != "" {
+			schedule.Log = val
+		}
+
+		if err := setScanSchedule(schedule); err != nil {
+			fmt.Println("Failed to schedule virus scan:", err)
+			return
+		}
+		fmt.Println("Scheduled virus scan: " + schedule.Name)
+	case "remove", "rm":
+		schedules := []*scanSchedule{}
+		found := false
+		for _, s := range loadScanSchedules() {
+			if s.Name == name {
+				s.remove()
+				found = true
+				continue
+			}
+			schedules = append(schedules, s)
+		}
+		saveScanSchedules(schedules)
+
+		if !found {
+			fmt.Println("No scheduled virus scan named: " + name)
+			return
+		}
+		fmt.Println("Removed scheduled virus scan: " + name)
+	default:
+		schedules := loadScanSchedules()
+		if len(schedules) == 0 {
+			fmt.Println("No scheduled virus scans")
+			return
+		}
+
+		for _, s := range schedules {
+			next := "inactive"
+			if out, err := bash.Run([]string{`systemctl`, `show`, s.unit() + ".timer", `--property=NextElapseUSecRealtime`, `--value`}, "", nil); err == nil && len(strings.TrimSpace(string(out))) != 0 {
+				next = strings.TrimSpace(string(out))
+			}
+
+			fmt.Println(s.Name + ":")
+			fmt.Println("  Schedule: " + s.Calendar)
+			fmt.Println("  Next Run: " + next)
+			fmt.Println("  Paths:    " + strings.Join(s.Paths, " "))
+			fmt.Println("  Exclude:  " + strings.Join(s.Exclude, " "))
+			fmt.Println("  Nice:     " + strconv.Itoa(s.Nice))
+			fmt.Println("  Log:      " + s.Log)
+		}
+	}
+}
+
+// clamd config locations (fedora, debian/ubuntu)
+var clamdConfigPaths = []string{"/etc/clamd.d/scan.conf", "/etc/clamav/clamd.conf"}
+
+type clamdConfig struct {
+	path  string
+	lines []string
+}
+
+func readClamdConfig() *clamdConfig {
+	for _, path := range clamdConfigPaths {
+		if buf, err := os.ReadFile(path); err == nil {
+			return &clamdConfig{path: path, lines: strings.Split(strings.TrimRight(string(buf), "\n"), "\n")}
+		}
+	}
+	return nil
+}
+
+func (conf *clamdConfig) get(key string) string {
+	for _, line := range conf.lines {
+		if k, v, _ := strings.Cut(strings.TrimSpace(line), " "); k == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// set replaces every value of a key (clamd allows some keys more than once), or adds it to the end of the file
+func (conf *clamdConfig) set(key string, vals ...string) {
+	lines := []string{}
+	index := -1
+
+	for _, line := range conf.lines {
+		if k, _, _ := strings.Cut(strings.TrimSpace(line), " "); k == key {
+			if index == -1 {
+				index = len(lines)
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if index == -1 {
+		index = len(lines)
+	}
+
+	set := []string{}
+	for _, val := range vals {
+		set = append(set, key+" "+val)
+	}
+
+	conf.lines = append(lines[:index], append(set, lines[index:]...)...)
+}
+
+// comment disables a key, if it is set
+func (conf *clamdConfig) comment(key string) {
+	for i, line := range conf.lines {
+		if k, _, _ := strings.Cut(strings.TrimSpace(line), " "); k == key {
+			conf.lines[i] = "#" + line
+		}
+	}
+}
+
+func (conf *clamdConfig) save() error {
+	return os.WriteFile(conf.path, []byte(strings.Join(conf.lines, "\n")+"\n"), 0644)
+}
+
+// clamavServices returns the clamd daemon and clamonacc service names for this distro
+func clamavServices() (string, string) {
+	daemon := "clamav-daemon"
+	if PM == "dnf" {
+		daemon = "clamd@scan"
+	}
+
+	onacc := "clamav-clamonacc"
+	for _, name := range []string{"clamav-clamonacc", "clamonacc"} {
+		if out, err := bash.Run([]string{`systemctl`, `list-unit-files`, name + `.service`}, "", nil); err == nil && strings.Contains(string(out), name+".service") {
+			onacc = name
+			break
+		}
+	}
+
+	return daemon, onacc
+}
+
+// homeDirs returns the home directories of regular users
+func homeDirs() []string {
+	dirs := []string{}
+
+	if buf, err := os.ReadFile("/etc/passwd"); err == nil {
+		for _, line := range strings.Split(string(buf), "\n") {
+			fields := strings.Split(line, ":")
+			if len(fields) < 6 {
+				continue
+			}
+
+			if uid, err := strconv.Atoi(fields[2]); err != nil || uid < 1000 || uid >= 65534 {
+				continue
+			}
+
+			if stat, err := os.Stat(fields[5]); err == nil && stat.IsDir() && !goutil.Contains(dirs, fields[5]) {
+				dirs = append(dirs, fields[5])
+			}
+		}
+	}
+
+	if len(dirs) == 0 {
+		dirs = append(dirs, "/home")
+	}
+
+	return dirs
+}
+
+func installClamav() {
+	if PM == "dnf" {
+		installPKG(`clamav`, `clamd`, `clamav-update`)
+	} else if PM == "apt" {
+		installPKG(`clamav`, `clamav-daemon`, `clamav-freshclam`)
+	}
+
+	bash.Run([]string{`systemctl`, `stop`, `clamav-freshclam`}, "", nil)
+	bash.Run([]string{`freshclam`}, "", nil)
+	bash.Run([]string{`systemctl`, `enable`, `--now`, `clamav-freshclam`}, "", nil)
+}
+
+// configureClamd enables the clamd daemon and on-access scanning of home directories
+func configureClamd() {
+	conf := readClamdConfig()
+	if conf == nil {
+		fmt.Println("No clamd config found, skipping on-access scanning")
+		return
+	}
+
+	// fedora ships the config disabled, with an example line
+	conf.comment("Example")
+
+	if conf.path == "/etc/clamd.d/scan.conf" {
+		conf.set("LocalSocket", "/run/clamd.scan/clamd.sock")
+	}
+
+	// remove options from older versions of this tool
+	conf.comment("ScanOnAccess")
+	conf.comment("OnAccessMountPath")
+	conf.comment("OnAccessExcludeUID")
+	if conf.get("User") == "root" {
+		if PM == "dnf" {
+			conf.set("User", "clamscan")
+		} else {
+			conf.set("User", "clamav")
+		}
+	}
+
+	conf.set("OnAccessIncludePath", homeDirs()...)
+	conf.set("OnAccessExcludeRootUID", "yes")
+	conf.set("OnAccessPrevention", "no")
+	conf.set("OnAccessExtraScanning", "yes")
+	conf.save()
+
+	daemon, onacc := clamavServices()
+
+	bash.Run([]string{`systemctl`, `enable`, daemon}, "", nil)
+	bash.Run([]string{`systemctl`, `restart`, daemon}, "", nil)
+	bash.Run([]string{`systemctl`, `enable`, onacc}, "", nil)
+	bash.Run([]string{`systemctl`, `restart`, onacc}, "", nil)
+
+	// clamd can take a while to load its signatures
+	for i := 0; i < 12 && !serviceActive(daemon); i++ {
+		time.Sleep(5 * time.Second)
+	}
+
+	for _, service := range []string{daemon, onacc} {
+		if serviceActive(service) {
+			fmt.Println("ClamAV: " + service + " is running")
+		} else {
+			fmt.Println("ClamAV: " + service + " failed to start (see: journalctl -u " + service + ")")
+		}
+	}
+}
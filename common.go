@@ -1,6 +1,14 @@
 package main
 
-import bash "github.com/tkdeng/gobash"
+import (
+	"os"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+const stateDir = "/var/lib/SpecialModifications"
 
 type config struct {
 	values map[string]string
@@ -58,6 +66,17 @@ func (c *config) addValue(key string, msg string, def string) string {
 	return def
 }
 
+func (c *config) addSelect(key string, msg string, opts ...string) string {
+	if AssumeYes {
+		c.values[key] = opts[0]
+		return opts[0]
+	}
+
+	val := opts[bash.InputSelect(msg, opts...)]
+	c.values[key] = val
+	return val
+}
+
 func (c *config) setValue(key string, value string) {
 	c.values[key] = value
 }
@@ -143,3 +162,41 @@ func hasPKG(pkg ...string) bool {
 
 	return true
 }
+
+// rebootRequired returns true if installed updates or a scheduled selinux relabel need a reboot
+func rebootRequired() bool {
+	for _, path := range []string{"/var/run/reboot-required", "/.autorelabel"} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+
+	if PM == "dnf" {
+		out, _ := bash.Run([]string{`dnf`, `needs-restarting`, `-r`}, "", nil)
+		return strings.Contains(string(out), "Reboot is required")
+	}
+
+	return false
+}
+
+func serviceActive(name string) bool {
+	out, err := bash.Run([]string{`systemctl`, `is-active`, name}, "", nil)
+	return err == nil && strings.TrimSpace(string(out)) == "active"
+}
+
+func osRelease(key string) string {
+	if buf, err := os.ReadFile("/etc/os-release"); err == nil {
+		for _, line := range strings.Split(string(buf), "\n") {
+			if k, v, ok := strings.Cut(line, "="); ok && k == key {
+				return strings.Trim(v, `"'`)
+			}
+		}
+	}
+
+	return ""
+}
+
+// osLike returns true if the distro is id, or is based on it
+func osLike(id string) bool {
+	return osRelease("ID") == id || goutil.Contains(strings.Fields(osRelease("ID_LIKE")), id)
+}
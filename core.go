@@ -22,26 +22,16 @@ type coreInstaller struct {
 }
 
 func installConfig(opts *config) {
-	if PM == "dnf" {
-		if opts.addBool("ufw", "Would you like to install UFW (Uncomplicated Firewall)?", true) {
-			fmt.Println("Using UFW...")
-		} else {
-			fmt.Println("Using Firewalld...")
-		}
-	} else {
-		opts.setBool("ufw", true)
+	switch opts.addSelect("firewall", "Which firewall would you like to use?", "ufw", "firewalld", "nftables") {
+	case "firewalld":
+		fmt.Println("Using Firewalld...")
+	case "nftables":
+		fmt.Println("Using nftables...")
+	default:
+		fmt.Println("Using UFW...")
 	}
 
-	if opts.addBool("cloudflareDNS", "Would you like to use Cloudflare DNS?", true) {
-		fmt.Println("Using Cloudflare DNS...")
-		if opts.addBool("googleFallbackDNS", "Would you like to use Google DNS as a fallback?", true) {
-			fmt.Println("Using Google Fallback DNS...")
-		} else {
-			fmt.Println("Using Cloudflare Fallback DNS...")
-		}
-	} else {
-		fmt.Println("Using Google DNS...")
-	}
+	dnsConfig(opts)
 
 	if !SSHClient {
 		opts.addBool("disableSSH", "Would you like to disable SSH?", true)
@@ -49,16 +39,41 @@ func installConfig(opts *config) {
 		opts.setBool("disableSSH", false)
 	}
 
+	if !opts.bool("disableSSH") {
+		opts.addBool("hardenSSH", "Would you like to harden the SSH server (key only login, no root login)?", true)
+	}
+
+	passwordConfig(opts)
+
+	sysctlConfig(opts)
+
+	firewallConfig(opts)
+
+	fail2banConfig(opts)
+
+	clamavConfig(opts)
+	rkhunterConfig(opts)
+
+	updatesConfig(opts)
+
+	macConfig(opts)
+
+	debloatConfig(opts)
+
+	toolchainsConfig(opts)
+
 	time.Sleep(1 * time.Second)
 }
 
-func installCore(opts *config) {
+// installCore installs the core modifications, and returns true if a reboot is needed to finish
+func installCore(opts *config) bool {
 	progressBar := bash.NewProgressBar("Installing")
 	defer progressBar.Stop()
 
 	core := &coreInstaller{progressBar: progressBar, opts: opts}
 
-	progressBar.SetSize(19)
+	progressBar.SetSize(18)
+	progressBar.AddSize(len(selectedToolchains(opts)))
 
 	core.countFiles("")
 
@@ -71,11 +86,7 @@ func installCore(opts *config) {
 		}
 	}
 
-	if opts.bool("ufw") {
-		progressBar.AddSize(1)
-	}
-
-	if opts.bool("disableSSH") {
+	if opts.bool("disableSSH") || opts.bool("hardenSSH") {
 		progressBar.AddSize(1)
 	}
 
@@ -91,81 +102,51 @@ func installCore(opts *config) {
 	update(true)
 	core.progressBar.Step()
 
-	//* install ufw
-	if core.opts.bool("ufw") {
-		core.progressBar.Msg("Installing UFW")
-		installPKG("ufw")
-		bash.Run([]string{`systemctl`, `enable`, `--now`, `ufw`}, "", nil, true)
+	//* install firewall
+	core.progressBar.Msg("Configuring Firewall")
+	policy := firewallPolicyFor(core.opts)
 
+	setupFirewall(core.opts, core.opts.value("firewall"), policy, func(fw firewall) {
+		fw.install()
 		if !SSHClient {
-			bash.RunRaw(`for i in $(ufw status | wc -l); do ufw --force delete 1; done`, "", nil)
+			fw.reset()
 		}
-
-		bash.Run([]string{`ufw`, `default`, `deny`, `incoming`}, "", nil)
-		bash.Run([]string{`ufw`, `default`, `allow`, `outgoing`}, "", nil)
-		bash.Run([]string{`ufw`, `enable`}, "", nil)
-
-		bash.Run([]string{`systemctl`, `disable`, `--now`, `firewalld`}, "", nil)
-		core.progressBar.Step()
-	}
+	})
+	core.progressBar.Step()
 
 	//* secure dns
 	core.progressBar.Msg("Securing DNS")
-
-	// fix moved systemd/resolved.conf file
-	if _, err := os.Stat("/etc/systemd/resolved.conf"); err != nil {
-		if _, err := os.Stat("/usr/lib/systemd/resolved.conf"); err == nil {
-			bash.Run([]string{`ln`, `-s`, `/usr/lib/systemd/resolved.conf`, `/etc/systemd/resolved.conf`}, "", nil)
-		}
-	}
-
-	if file, err := os.Open("/etc/systemd/resolved.conf"); err == nil {
-		regex.Comp(`(?m)^#?DNSSEC=.*$`).RepFile(file, []byte(`DNSSEC=yes`), false)
-		regex.Comp(`(?m)^#?DNSOverTLS=.*$`).RepFile(file, []byte(`DNSOverTLS=yes`), false)
-		regex.Comp(`(?m)^#?Cache=.*$`).RepFile(file, []byte(`Cache=yes`), false)
-
-		if core.opts.bool("cloudflareDNS") {
-			regex.Comp(`(?m)^#?DNS=.*$`).RepFile(file, []byte(`DNS=1.1.1.2#security.cloudflare-dns.com 2606:4700:4700::1112#security.cloudflare-dns.com`), false)
-
-			if core.opts.bool("googleFallbackDNS") {
-				regex.Comp(`(?m)^#?FallbackDNS=.*$`).RepFile(file, []byte(`FallbackDNS=8.8.4.4#dns.google 2001:4860:4860::8844#dns.google`), false)
-			} else {
-				regex.Comp(`(?m)^#?FallbackDNS=.*$`).RepFile(file, []byte(`FallbackDNS=1.0.0.2#security.cloudflare-dns.com`), false)
-			}
-
-			regex.Comp(`(?m)^#?Domains=.*$`).RepFile(file, []byte(`Domains=security.cloudflare-dns.com?ip=1.1.1.2&name=Cloudflare&blockedif=zeroip dns.google`), false)
-		} else {
-			regex.Comp(`(?m)^#?DNS=.*$`).RepFile(file, []byte(`DNS=8.8.8.8#dns.google 2001:4860:4860::8888#dns.google`), false)
-			regex.Comp(`(?m)^#?FallbackDNS=.*$`).RepFile(file, []byte(`FallbackDNS=8.8.4.4#dns.google 2001:4860:4860::8844#dns.google`), false)
-			regex.Comp(`(?m)^#?Domains=.*$`).RepFile(file, []byte(`Domains=dns.google`), false)
-		}
-
-		file.Sync()
-		file.Close()
-	}
-
-	bash.Run([]string{`systemctl`, `restart`, `systemd-resolved`}, "", nil)
-	bash.Run([]string{`resolvectl`, `flush-caches`}, "", nil)
+	secureDNS(core.opts)
 	core.progressBar.Step()
 
 	core.progressBar.Msg("Testing DNS")
-	bash.RunRaw(`if [ "$(timeout 10 ping -c1 google.com 2>/dev/null)" = "" ]; then sed -r -i 's/^DNSSEC=.*$/DNSSEC=allow-downgrade/m' /etc/systemd/resolved.conf; systemctl restart systemd-resolved; resolvectl flush-caches; fi`, "", nil)
-	core.progressBar.Step()
-
-	bash.RunRaw(`if [ "$(timeout 10 ping -c1 google.com 2>/dev/null)" = "" ]; then sed -r -i 's/^DNSSEC=/#DNSSEC=/m' /etc/systemd/resolved.conf; systemctl restart systemd-resolved; resolvectl flush-caches; fi`, "", nil)
+	dnsReport := verifyDNS(core.opts).report()
+	os.MkdirAll(stateDir, 0700)
+	os.WriteFile(stateDir+"/dns-check.txt", []byte(dnsReport+"\n"), 0644)
+	fmt.Println(dnsReport)
 	core.progressBar.Step()
 
 	//* disable ssh for desktop
 	if !SSHClient && opts.bool("disableSSH") {
-		bash.Run([]string{`systemctl`, `disable`, `sshd`, `--now`}, "", nil)
-		bash.RunRaw(`if test -f "/etc/ssh/sshd_config"; then sed -r -i 's/^PermitRootLogin (.*)$/PermitRootLogin no/m' "/etc/ssh/sshd_config"; sed -r -i 's/^PasswordAuthentication (.*)$/PasswordAuthentication no/m' "/etc/ssh/sshd_config"; fi`, "", nil)
-
-		//* set password quality rules
-		bash.RunRaw(`if test -f "/etc/security/pwquality.conf"; then sed -r -i 's/^# difoc = (.*)$/  difoc = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# minlen = (.*)$/  minlen = 4/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# dcredit = (.*)$/  dcredit = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# ucredit = (.*)$/  ucredit = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# lcredit = (.*)$/  lcredit = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# ocredit = (.*)$/  ocredit = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# minclass = (.*)$/  minclass = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# maxrepeat = (.*)$/  maxrepeat = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# gecoscheck = (.*)$/  gecoscheck = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# dictcheck = (.*)$/  dictcheck = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# usercheck = (.*)$/  usercheck = 1/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# usersubstr = (.*)$/  usersubstr = 0/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# enforcing = (.*)$/  enforcing = 1/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# retry = (.*)$/  retry = 3/m' "/etc/security/pwquality.conf"; sed -r -i 's/^# local_users_only$/  local_users_only/m' "/etc/security/pwquality.conf"; fi`, "", nil)
-
+		core.progressBar.Msg("Disabling SSH")
+		disableSSH()
+		core.progressBar.Step()
+	} else if opts.bool("hardenSSH") {
+		core.progressBar.Msg("Hardening SSH")
+		hardenSSH()
 		core.progressBar.Step()
 	}
 
+	//* harden kernel settings
+	core.progressBar.Msg("Hardening Kernel Settings")
+	hardenSysctl(core.opts)
+	core.progressBar.Step()
+
+	//* set password quality rules
+	core.progressBar.Msg("Setting Password Policy")
+	setPasswordPolicy(core.opts)
+	core.progressBar.Step()
+
 	//* install nala
 	if PM == "apt" && !hasNalaPM {
 		core.progressBar.Msg("Installing Nala")
@@ -183,70 +164,49 @@ func installCore(opts *config) {
 
 	//* install fail2ban
 	core.progressBar.Msg("Installing Fail2Ban")
-	installPKG(`fail2ban`)
-	bash.RunRaw(`if ! [ -f "/etc/fail2ban/jail.local" ]; then touch "/etc/fail2ban/jail.local"; echo '[DEFAULT]' | tee -a "/etc/fail2ban/jail.local"; echo 'ignoreip = 127.0.0.1/8 ::1' | tee -a "/etc/fail2ban/jail.local"; echo 'bantime = 3600' | tee -a "/etc/fail2ban/jail.local"; echo 'findtime = 600' | tee -a "/etc/fail2ban/jail.local"; echo 'maxretry = 5' | tee -a "/etc/fail2ban/jail.local"; echo '' | tee -a "/etc/fail2ban/jail.local"; echo '[sshd]' | tee -a "/etc/fail2ban/jail.local"; echo 'enabled = true' | tee -a "/etc/fail2ban/jail.local"; fi`, "", nil)
-	bash.Run([]string{`systemctl`, `enable`, `--now`, `fail2ban`}, "", nil)
+	installFail2ban(core.opts)
 	core.progressBar.Step()
 
 	//* install clamav
 	core.progressBar.Msg("Installing Clamav")
-	if PM == "dnf" {
-		installPKG(`clamav`, `clamd`, `clamav-update`, `cronie`)
-	} else if PM == "apt" {
-		installPKG(`clamav`, `clamav-daemon`, `clamav-update`, `cronie`)
-	}
-	bash.Run([]string{`systemctl`, `stop`, `clamav-freshclam`}, "", nil)
-	bash.Run([]string{`freshclam`}, "", nil)
-	bash.Run([]string{`systemctl`, `enable`, `--now`, `clamav-freshclam`}, "", nil)
-	bash.Run([]string{`freshclam`}, "", nil)
+	installClamav()
 	core.progressBar.Step()
 
-	//* fix clamav permissions
-	os.MkdirAll("/VirusScan/quarantine", 0664)
-	bash.RunRaw(`if grep -R "^ScanOnAccess " "/etc/clamd.d/scan.conf"; then sed -r -i 's/^ScanOnAccess (.*)$/ScanOnAccess yes/m' /etc/clamd.d/scan.conf; else echo 'ScanOnAccess yes' | tee -a /etc/clamd.d/scan.conf; fi`, "", nil)
-	bash.RunRaw(`if grep -R "^OnAccessMountPath " "/etc/clamd.d/scan.conf"; then sed -r -i 's#^OnAccessMountPath (.*)$#OnAccessMountPath /#m' /etc/clamd.d/scan.conf; else echo 'OnAccessMountPath /' | tee -a /etc/clamd.d/scan.conf; fi`, "", nil)
-	bash.RunRaw(`if grep -R "^OnAccessPrevention " "/etc/clamd.d/scan.conf"; then sed -r -i 's/^OnAccessPrevention (.*)$/OnAccessPrevention no/m' /etc/clamd.d/scan.conf; else echo 'OnAccessPrevention no' | tee -a /etc/clamd.d/scan.conf; fi`, "", nil)
-	bash.RunRaw(`if grep -R "^OnAccessExtraScanning " "/etc/clamd.d/scan.conf"; then sed -r -i 's/^OnAccessExtraScanning (.*)$/OnAccessExtraScanning yes/m' /etc/clamd.d/scan.conf; else echo 'OnAccessExtraScanning yes' | tee -a /etc/clamd.d/scan.conf; fi`, "", nil)
-	bash.RunRaw(`if grep -R "^OnAccessExcludeUID " "/etc/clamd.d/scan.conf"; then sed -r -i 's/^OnAccessExcludeUID (.*)$/OnAccessExcludeUID 0/m' /etc/clamd.d/scan.conf; else echo 'OnAccessExcludeUID 0' | tee -a /etc/clamd.d/scan.conf; fi`, "", nil)
-	bash.RunRaw(`if grep -R "^User " "/etc/clamd.d/scan.conf"; then sed -r -i 's/^User (.*)$/User root/m' /etc/clamd.d/scan.conf; else echo 'User root' | tee -a /etc/clamd.d/scan.conf; fi`, "", nil)
-	bash.Run([]string{`freshclam`}, "", nil)
+	//* configure on-access scanning
+	core.progressBar.Msg("Configuring Clamav")
+	if err := setQuarantinePath(core.opts.value("quarantineDir")); err != nil {
+		fmt.Println("Failed to create quarantine directory:", err)
+	}
+	configureClamd()
 	core.progressBar.Step()
 
 	//* install other security tools
 	core.progressBar.Msg("Installing Security Tools")
 	if PM == "dnf" {
-		installPKG(`rkhunter`, `bleachbit`, `pwgen`, `dnf-automatic`, `selinux-policy-devel`)
-		bash.RunRaw(`sed -r -i 's/^apply_updates(\s*)=(\s*)(.*)$/apply_updates\1=\2yes/m' "/etc/dnf/automatic.conf"`, "", nil)
-		bash.Run([]string{`systemctl`, `enable`, `--now`, `dnf-automatic.timer`}, "", nil)
+		installPKG(`bleachbit`, `pwgen`)
 	} else if PM == "apt" {
-		installPKG(`rkhunter`, `bleachbit`, `pwgen`, `unattended-upgrades`, `debconf-utils`, `apparmor-utils`)
-
-		//todo: fix rkhunter trying to get mail setup
-		// seemed to freeze up at:
-		//   the file python3.dll was found in c:\ or c:\dlls, which indicates a possible attempt at DLL search-order hijacking
-		//
-		// this gets repeated a lot:
-		//   /usr/share/bleachbit/bleachbit/windows.py:157: SyntaxWarning: invalid escape sequence
-		//
-		// manually hitting ctrl+c or enter was necessary to continue process:
-		//   WEB_CMD configuration option: Relative pathname: "/bin/false"
-
-		bash.RunRaw(`debconf-get-selections | grep <package-name> > temp-preseed.conf; sed -r -i 's/false$/true/m' temp-preseed.conf; debconf-set-selections temp-preseed.conf; rm -f temp-preseed.conf`, "", nil)
-		bash.Run([]string{`dpkg-reconfigure`, `--priority=low`, `-u`, `unattended-upgrades`}, "", nil)
+		installPKG(`bleachbit`, `pwgen`, `debconf-utils`)
 	}
 	core.progressBar.Step()
 
+	//* selinux and apparmor
+	core.progressBar.Msg("Configuring SELinux/AppArmor")
+	reboot := configureMAC(core.opts)
+	core.progressBar.Step()
+
+	//* automatic updates
+	core.progressBar.Msg("Configuring Automatic Updates")
+	configureUpdates(core.opts)
+	core.progressBar.Step()
+
 	core.progressBar.Msg("Initializing RKhunter")
-	bash.Run([]string{`rkhunter`, `--update`}, "", nil, true)
-	bash.Run([]string{`rkhunter`, `--propupd`}, "", nil, true)
+	installRkhunter(core.opts)
+	core.progressBar.Step()
 
 	//* schedule scans
-	bash.RunRaw(`if ! [[ $(crontab -l) == *"# clamav-scan"* ]] ; then crontab -l | { cat; echo '0 2 * * * nice -n 15 clamscan && clamscan -r --bell --move="/VirusScan/quarantine" --exclude-dir="/VirusScan/quarantine" --exclude-dir="/home/$USER/.clamtk/viruses" --exclude-dir="smb4k" --exclude-dir="/run/user/$USER/gvfs" --exclude-dir="/home/$USER/.gvfs" --exclude-dir=".thunderbird" --exclude-dir=".mozilla-thunderbird" --exclude-dir=".evolution" --exclude-dir="Mail" --exclude-dir="kmail" --exclude-dir="^/sys" / # clamav-scan'; } | crontab -; fi`, "", nil)
+	scheduleScans(core.opts)
 	core.progressBar.Step()
 
-	//todo: add scheduled scans to virus scanning app
-	// also make new virus scanning app that uses clamav
-
 	if PM == "dnf" {
 		//* install rpm repos
 		core.progressBar.Msg("Installing RPM repos")
@@ -334,92 +294,23 @@ func installCore(opts *config) {
 
 	//* disable startups
 	core.progressBar.Msg("Disabling Time Wasting Programs")
-	bash.Run([]string{`systemctl`, `disable`, `accounts-daemon.service`}, "", nil) // is a potential securite risk
-	bash.Run([]string{`systemctl`, `disable`, `debug-shell.service`}, "", nil)     // opens a giant security hole
-	removePKG(`dmraid`)
-	if PM == "dnf" {
-		removePKG(`device-mapper-multipath`)
-	}
+	debloat(core.opts)
 	core.progressBar.Step()
 
 	//* install programming languages
 	core.progressBar.Msg("Installing programming languages")
-
-	//* install python
-	core.progressBar.Msg("Installing Python")
-	installPKG(`python`, `python3`, `python-pip`, `python3-pip`)
-	core.progressBar.Step()
-
-	//* install c
-	core.progressBar.Msg("Installing C")
-	installPKG(`gcc-c++`, `make`, `gcc`)
-	core.progressBar.Step()
-
-	//* install java
-	core.progressBar.Msg("Making Java")
-	if PM == "apt" {
-		// installPKG(`openjdk-8-jre`, `openjdk-8-jdk`, `openjdk-11-jre`, `openjdk-11-jdk`)
-		installPKG(`openjdk-8-jre`, `openjdk-8-jdk`, `openjdk-25-jre`, `openjdk-25-jdk`)
-	} else {
-		// installPKG(`java-1.8.0-openjdk`, `java-11-openjdk`, `java-latest-openjdk`)
-		installPKG(`java-1.8.0-openjdk`, `java-25-openjdk`, `java-latest-openjdk`)
-	}
-	core.progressBar.Step()
-
-	//* install git and node
-	core.progressBar.Msg("Installing Git and Node")
-	if PM == "dnf" {
-		installPKG(`git`, `nodejs`, `npm`)
-	} else if PM == "apt" {
-		installPKG(`git`, `nodejs`)
-		if hasNalaPM {
-			bash.Run([]string{`nala`, `install`, `-y`, `--no-install-recommends`, `npm`}, "", nil)
-		} else {
-			bash.Run([]string{`apt`, `-y`, `--no-install-recommends`, `install`, `npm`}, "", nil)
-		}
-	}
-	core.progressBar.Step()
-
-	//* install golang
-	core.progressBar.Msg("Installing Go")
-	if PM == "dnf" {
-		installPKG(`golang`, `pcre-devel`)
-	} else if PM == "apt" {
-		installPKG(`golang`, `libpcre3-dev`)
-	}
-	core.progressBar.Step()
-
-	//* docker
-	core.progressBar.Msg("Installing Docker")
-	if PM == "dnf" {
-		installPKG(`dnf-plugins-core`)
-		bash.Run([]string{`dnf`, `config-manager`, `--add-repo`, `https://download.docker.com/linux/fedora/docker-ce.repo`}, "", nil)
-		installPKG(`docker-ce`, `docker-ce-cli`, `containerd.io`, `docker-buildx-plugin`, `docker-compose-plugin`)
-		installPKG(`docker`)
-		bash.Run([]string{`systemctl`, `enable`, `docker`, `--now`}, "", nil)
-	} else if PM == "apt" {
-		installPKG(`ca-certificates`, `curl`)
-		bash.Run([]string{`install`, `-m`, `0755`, `-d`, `/etc/apt/keyrings`}, "", nil)
-		bash.Run([]string{`curl`, `-fsSL`, `https://download.docker.com/linux/ubuntu/gpg`, `-o`, `/etc/apt/keyrings/docker.asc`}, "", nil)
-		bash.Run([]string{`chmod`, `a+r`, `/etc/apt/keyrings/docker.asc`}, "", nil)
-		bash.Run([]string{`echo "deb [arch=$(dpkg --print-architecture) signed-by=/etc/apt/keyrings/docker.asc] https://download.docker.com/linux/ubuntu $(. /etc/os-release && echo "${UBUNTU_CODENAME:-$VERSION_CODENAME}") stable" | sudo tee /etc/apt/sources.list.d/docker.list > /dev/null`}, "", nil)
-		bash.Run([]string{`apt`, `-y`, `update`}, "", nil)
-		if hasNalaPM {
-			bash.Run([]string{`nala`, `update`}, "", nil)
-		}
-		installPKG(`docker-ce`, `docker-ce-cli`, `containerd.io`, `docker-buildx-plugin`, `docker-compose-plugin`)
-		bash.Run([]string{`systemctl`, `enable`, `docker`, `--now`}, "", nil)
-	}
-	core.progressBar.Step()
+	installToolchains(core.opts, core.progressBar)
 
 	//* install common apps
 	core.progressBar.Msg("Installing Common Packages")
 
 	if PM == "dnf" {
-		installPKG(`nano`, `micro`, `neofetch`, `qemu-guest-agent`, `tuned`, `btrfs-progs`, `lvm2`, `xfsprogs`, `ntfs-3g`, `ntfsprogs`, `exfatprogs`, `udftools`, `p7zip`, `p7zip-plugins`, `hplip`, `hplip-gui`, `inotify-tools`, `guvcview`)
-		bash.Run([]string{`systemctl`, `enable`, `sshd.socket`, `--now`}, "", nil)
+		installPKG(`git`, `nano`, `micro`, `neofetch`, `qemu-guest-agent`, `tuned`, `btrfs-progs`, `lvm2`, `xfsprogs`, `ntfs-3g`, `ntfsprogs`, `exfatprogs`, `udftools`, `p7zip`, `p7zip-plugins`, `hplip`, `hplip-gui`, `inotify-tools`, `guvcview`)
+		if !opts.bool("disableSSH") {
+			bash.Run([]string{`systemctl`, `enable`, `sshd.socket`, `--now`}, "", nil)
+		}
 	} else if PM == "apt" {
-		installPKG(`nano`, `micro`, `neofetch`, `qemu-guest-agent`, `tuned`, `btrfs-progs`, `lvm2`, `xfsprogs`, `ntfs-3g`, `ntfs-3g`, `exfatprogs`, `udftools`, `p7zip`, `hplip`, `hplip-gui`, `inotify-tools`, `guvcview`)
+		installPKG(`git`, `nano`, `micro`, `neofetch`, `qemu-guest-agent`, `tuned`, `btrfs-progs`, `lvm2`, `xfsprogs`, `ntfs-3g`, `ntfs-3g`, `exfatprogs`, `udftools`, `p7zip`, `hplip`, `hplip-gui`, `inotify-tools`, `guvcview`)
 	}
 	bash.Run([]string{`systemctl`, `enable`, `fstrim.timer`, `--now`}, "", nil)
 	bash.Run([]string{`systemctl`, `enable`, `systemd-oomd.service`, `--now`}, "", nil)
@@ -435,9 +326,33 @@ func installCore(opts *config) {
 	core.progressBar.Msg("Updating")
 	update(true)
 	core.progressBar.Step()
+
+	saveApplied(core.opts)
+
+	return reboot || rebootRequired()
 }
 
 func (core *coreInstaller) files() {
+	filePerms := assetPerms()
+	core.installFiles(&filePerms, "", 0755)
+}
+
+// readAsset returns a file from assets/fs, with the values chosen by other steps filled in
+func readAsset(path string) ([]byte, error) {
+	buf, err := assetFS.ReadFile("assets/fs" + path)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == dnfConfPath {
+		buf = regex.Comp(`(?m)^installonly_limit=.*$`).Rep(buf, []byte(`installonly_limit=`+strconv.Itoa(kernelKeep())))
+	}
+
+	return buf, nil
+}
+
+// assetPerms returns the file modes from assets/fs/.perms.json
+func assetPerms() map[string]os.FileMode {
 	filePerms := map[string]os.FileMode{}
 
 	if buf, err := os.ReadFile("assets/fs/.perms.json"); err == nil {
@@ -455,7 +370,7 @@ func (core *coreInstaller) files() {
 		}
 	}
 
-	core.installFiles(&filePerms, "", 0755)
+	return filePerms
 }
 
 func (core *coreInstaller) countFiles(dir string) {
@@ -493,7 +408,7 @@ func (core *coreInstaller) installFiles(filePerms *map[string]os.FileMode, dir s
 				continue
 			}
 
-			if buf, err := assetFS.ReadFile("assets/fs" + path); err == nil {
+			if buf, err := readAsset(path); err == nil {
 				var perm os.FileMode = 0644
 				if val, ok := (*filePerms)[path]; ok {
 					perm = val
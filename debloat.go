@@ -0,0 +1,178 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+//go:embed assets/debloat.json
+var debloatJSON []byte
+
+type debloatItem struct {
+	Name       string   `json:"name"`
+	Action     string   `json:"action"` // "disable", "mask" or "remove"
+	PM         string   `json:"pm"`     // only for this package manager, if set
+	Services   []string `json:"services"`
+	Packages   []string `json:"packages"`
+	Risk       string   `json:"risk"`
+	Default    bool     `json:"default"`
+	RequiredBy []string `json:"requiredBy"` // packages that stop working without it
+	InUse      string   `json:"inUse"`      // command that succeeds if the system is using it
+	Rationale  string   `json:"rationale"`
+}
+
+var debloatCatalog = []debloatItem{}
+
+func init() {
+	if err := json.Unmarshal(debloatJSON, &debloatCatalog); err != nil {
+		panic(err)
+	}
+}
+
+func (item *debloatItem) key() string {
+	return "debloat-" + item.Name
+}
+
+// present returns true if any of the services or packages are on the system
+func (item *debloatItem) present() bool {
+	if item.PM != "" && item.PM != PM {
+		return false
+	}
+
+	for _, service := range item.Services {
+		if out, err := bash.Run([]string{`systemctl`, `list-unit-files`, service}, "", nil); err == nil && strings.Contains(string(out), service) {
+			return true
+		}
+	}
+
+	for _, pkg := range item.Packages {
+		if hasPKG(pkg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blocker returns the reason an item has to be kept, or an empty string if it is safe to change
+func (item *debloatItem) blocker() string {
+	needed := []string{}
+	for _, pkg := range item.RequiredBy {
+		if hasPKG(pkg) {
+			needed = append(needed, pkg)
+		}
+	}
+	if len(needed) != 0 {
+		return "needed by " + strings.Join(needed, ", ")
+	}
+
+	if item.InUse != "" {
+		if _, err := bash.RunRaw(item.InUse, "", nil); err == nil {
+			return "in use on this system"
+		}
+	}
+
+	return ""
+}
+
+func debloatConfig(opts *config) {
+	fmt.Println("")
+
+	for _, item := range debloatCatalog {
+		if !item.present() {
+			continue
+		}
+
+		if reason := item.blocker(); reason != "" {
+			fmt.Println("Keeping " + item.Name + ": " + reason)
+			opts.setBool(item.key(), false)
+			continue
+		}
+
+		fmt.Println(item.Name + " (" + item.Risk + " risk): " + item.Rationale)
+		opts.addBool(item.key(), strings.ToUpper(item.Action[:1])+item.Action[1:]+" "+item.Name+"?", item.Default)
+	}
+}
+
+// removalDependents returns the other packages that would be removed with pkgs
+func removalDependents(pkgs []string) ([]string, error) {
+	dependents := []string{}
+
+	switch PM {
+	case "apt":
+		out, err := bash.Run(append([]string{`apt-get`, `-s`, `remove`}, pkgs...), "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(string(out)))
+		}
+
+		for _, line := range strings.Split(string(out), "\n") {
+			if name, ok := strings.CutPrefix(line, "Remv "); ok {
+				name, _, _ = strings.Cut(name, " ")
+				if !goutil.Contains(pkgs, name) {
+					dependents = append(dependents, name)
+				}
+			}
+		}
+	case "dnf":
+		for _, pkg := range pkgs {
+			out, err := bash.Run([]string{`dnf`, `-q`, `repoquery`, `--installed`, `--whatrequires`, pkg, `--qf`, `%{name} `}, "", nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s", strings.TrimSpace(string(out)))
+			}
+
+			for _, name := range strings.Fields(string(out)) {
+				if !goutil.Contains(pkgs, name) && !goutil.Contains(dependents, name) {
+					dependents = append(dependents, name)
+				}
+			}
+		}
+	}
+
+	return dependents, nil
+}
+
+func debloat(opts *config) {
+	for _, item := range debloatCatalog {
+		if !opts.bool(item.key()) || !item.present() {
+			continue
+		}
+
+		if reason := item.blocker(); reason != "" {
+			fmt.Println("Keeping " + item.Name + ": " + reason)
+			continue
+		}
+
+		switch item.Action {
+		case "disable":
+			bash.Run(append([]string{`systemctl`, `disable`, `--now`}, item.Services...), "", nil)
+			fmt.Println("Disabled " + item.Name)
+		case "mask":
+			bash.Run(append([]string{`systemctl`, `mask`, `--now`}, item.Services...), "", nil)
+			fmt.Println("Masked " + item.Name)
+		case "remove":
+			pkgs := []string{}
+			for _, pkg := range item.Packages {
+				if hasPKG(pkg) {
+					pkgs = append(pkgs, pkg)
+				}
+			}
+
+			// never take other packages down with it
+			if dependents, err := removalDependents(pkgs); err != nil {
+				fmt.Println("Keeping " + item.Name + ": " + err.Error())
+				continue
+			} else if len(dependents) != 0 {
+				fmt.Println("Keeping " + item.Name + ": would also remove " + strings.Join(dependents, ", "))
+				continue
+			}
+
+			removePKG(pkgs...)
+			fmt.Println("Removed " + item.Name)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/regex"
+)
+
+//go:embed assets/dns-providers.json
+var dnsProvidersJSON []byte
+
+const resolvedDropInPath = "/etc/systemd/resolved.conf.d/special-modifications.conf"
+
+type dnsProvider struct {
+	Name  string   `json:"name"`
+	Label string   `json:"label"`
+	IPv4  []string `json:"ipv4"`
+	IPv6  []string `json:"ipv6"`
+	TLS   string   `json:"tls"` // DNS over TLS server name
+}
+
+var dnsProviders = []dnsProvider{}
+
+func init() {
+	if err := json.Unmarshal(dnsProvidersJSON, &dnsProviders); err != nil {
+		panic(err)
+	}
+}
+
+func dnsConfig(opts *config) {
+	names := []string{}
+	for _, provider := range dnsProviders {
+		names = append(names, provider.Name)
+	}
+	names = append(names, "custom")
+
+	fmt.Println("")
+	for _, provider := range dnsProviders {
+		fmt.Println(provider.Name + ": " + provider.Label)
+	}
+
+	primary := opts.addSelect("dnsProvider", "Which DNS provider would you like to use?", names...)
+	dnsProviderConfig(opts, "dnsProvider", primary)
+
+	// default the fallback to google, like before the catalog existed
+	fallbackNames := []string{"google"}
+	for _, name := range names {
+		if name != "google" {
+			fallbackNames = append(fallbackNames, name)
+		}
+	}
+
+	fallback := opts.addSelect("dnsFallback", "Which DNS provider would you like to use as a fallback?", fallbackNames...)
+	dnsProviderConfig(opts, "dnsFallback", fallback)
+
+	// stubby is only installed when it is picked, it replaces the dns of every connection
+	backend := detectDNSBackend()
+	if backend == "resolved" {
+		opts.setValue("dnsBackend", backend)
+	} else {
+		backend = opts.addSelect("dnsBackend", "systemd-resolved is not running, how should DNS be configured (stubby adds DNS over TLS)?", backend, "stubby")
+	}
+
+	fmt.Println("Using " + primary + " DNS with " + fallback + " fallback (" + backend + ")...")
+}
+
+// dnsProviderConfig asks for the extra values some providers need
+func dnsProviderConfig(opts *config, key string, name string) {
+	switch name {
+	case "nextdns":
+		opts.addValue(key+"Profile", "What is your NextDNS profile ID?", "")
+	case "custom":
+		opts.addValue(key+"Servers", "Which DNS server IP addresses would you like to use (space separated)?", "")
+		opts.addValue(key+"TLS", "What is the DNS over TLS server name (leave blank to disable)?", "")
+	}
+}
+
+// dnsServers returns the servers for a provider config key, in the "ip#tls-name" format used by resolved
+func dnsServers(opts *config, key string) []string {
+	name := opts.value(key)
+	servers := []string{}
+
+	if name == "custom" {
+		tls := opts.value(key + "TLS")
+		for _, ip := range strings.Fields(opts.value(key + "Servers")) {
+			if tls != "" {
+				ip += "#" + tls
+			}
+			servers = append(servers, ip)
+		}
+		return servers
+	}
+
+	for _, provider := range dnsProviders {
+		if provider.Name != name {
+			continue
+		}
+
+		tls := provider.TLS
+		if strings.Contains(tls, "{profile}") {
+			if profile := opts.value(key + "Profile"); profile != "" {
+				tls = strings.ReplaceAll(tls, "{profile}", profile)
+			} else {
+				tls = strings.ReplaceAll(tls, "{profile}.", "")
+			}
+		}
+
+		for _, ip := range append(append([]string{}, provider.IPv4...), provider.IPv6...) {
+			servers = append(servers, ip+"#"+tls)
+		}
+		return servers
+	}
+
+	return servers
+}
+
+// secureDNS renders the chosen providers into the chosen dns backend
+func secureDNS(opts *config) {
+	servers := dnsServers(opts, "dnsProvider")
+	if len(servers) == 0 {
+		fmt.Println("No DNS servers configured, skipping DNS setup")
+		return
+	}
+
+	backend := opts.value("dnsBackend")
+	if backend == "" {
+		backend = detectDNSBackend()
+		opts.setValue("dnsBackend", backend)
+	}
+	fmt.Println("Using " + backend + " for DNS...")
+
+	ips := []string{}
+	switch backend {
+	case "resolved":
+		configureResolved(opts)
+		return
+	case "stubby":
+		if err := configureStubby(opts); err != nil {
+			fmt.Println("Failed to set up stubby, DNS was left unchanged:", err)
+			return
+		}
+		ips = []string{"127.0.0.1", "::1"}
+	default:
+		for _, server := range append(servers, dnsServers(opts, "dnsFallback")...) {
+			ip, _, _ := strings.Cut(server, "#")
+			ips = append(ips, ip)
+		}
+	}
+
+	restore := setSystemDNS(ips)
+	if !systemResolves() {
+		fmt.Println("DNS lookups failed with the new servers, restoring the previous resolver...")
+		restore()
+		if backend == "stubby" {
+			bash.Run([]string{`systemctl`, `disable`, `--now`, `stubby`}, "", nil)
+		}
+	}
+}
+
+// detectDNSBackend picks systemd-resolved if it is running, then NetworkManager,
+// and /etc/resolv.conf as a last resort
+//
+// stubby is never detected, it has to be picked in dnsConfig
+func detectDNSBackend() string {
+	if serviceActive("systemd-resolved") {
+		return "resolved"
+	}
+
+	if serviceActive("NetworkManager") {
+		return "networkmanager"
+	}
+
+	return "resolvconf"
+}
+
+// systemResolves returns true if names resolve through the system resolver
+func systemResolves() bool {
+	// getent reads resolv.conf again, the go resolver may still have the old one cached
+	_, err := bash.Run([]string{`timeout`, `10`, `getent`, `ahosts`, `example.com`}, "", nil)
+	return err == nil
+}
+
+// resolvedConf returns the systemd-resolved drop-in for the chosen providers
+func resolvedConf(opts *config) string {
+	dot := "yes"
+	if opts.value("dnsProvider") == "custom" && opts.value("dnsProviderTLS") == "" {
+		dot = "opportunistic"
+	}
+
+	return "# generated by Special Modifications\n" +
+		"[Resolve]\n" +
+		"DNS=" + strings.Join(dnsServers(opts, "dnsProvider"), " ") + "\n" +
+		"FallbackDNS=" + strings.Join(dnsServers(opts, "dnsFallback"), " ") + "\n" +
+		"Domains=~.\n" +
+		"DNSSEC=yes\n" +
+		"DNSOverTLS=" + dot + "\n" +
+		"Cache=yes\n"
+}
+
+func configureResolved(opts *config) {
+	conf := resolvedConf(opts)
+
+	os.MkdirAll("/etc/systemd/resolved.conf.d", 0755)
+	os.WriteFile(resolvedDropInPath, []byte(conf), 0644)
+
+	bash.Run([]string{`systemctl`, `restart`, `systemd-resolved`}, "", nil)
+	bash.Run([]string{`resolvectl`, `flush-caches`}, "", nil)
+}
+
+const stubbyConfigPath = "/etc/stubby/stubby.yml"
+
+// configureStubby runs a local DNS over TLS resolver on 127.0.0.1
+//
+// the previous config is restored if stubby does not answer, so dns is never pointed at a broken resolver
+func configureStubby(opts *config) error {
+	upstreams := []string{}
+	for _, server := range append(dnsServers(opts, "dnsProvider"), dnsServers(opts, "dnsFallback")...) {
+		ip, tls, _ := strings.Cut(server, "#")
+		if tls == "" {
+			continue
+		}
+		upstreams = append(upstreams, "  - address_data: "+ip+"\n    tls_auth_name: \""+tls+"\"")
+	}
+
+	if len(upstreams) == 0 {
+		return fmt.Errorf("stubby needs a DNS over TLS server name, none of the chosen providers have one")
+	}
+
+	if out, err := bash.Run([]string{`which`, `stubby`}, "", nil); err != nil || len(out) == 0 {
+		if PM == "dnf" {
+			installPKG(`getdns-stubby`)
+		} else {
+			installPKG(`stubby`)
+		}
+	}
+
+	conf := `# generated by Special Modifications
+resolution_type: GETDNS_RESOLUTION_STUB
+dns_transport_list:
+  - GETDNS_TRANSPORT_TLS
+tls_authentication: GETDNS_AUTHENTICATION_REQUIRED
+tls_query_padding_blocksize: 128
+edns_client_subnet_private: 1
+round_robin_upstreams: 0
+idle_timeout: 10000
+dnssec: GETDNS_EXTENSION_TRUE
+listen_addresses:
+  - 127.0.0.1@53
+  - 0::1@53
+upstream_recursive_servers:
+` + strings.Join(upstreams, "\n") + "\n"
+
+	previous, prevErr := os.ReadFile(stubbyConfigPath)
+
+	os.MkdirAll("/etc/stubby", 0755)
+	os.WriteFile(stubbyConfigPath, []byte(conf), 0644)
+
+	restore := func() {
+		if prevErr == nil {
+			os.WriteFile(stubbyConfigPath, previous, 0644)
+			bash.Run([]string{`systemctl`, `try-restart`, `stubby`}, "", nil)
+		} else {
+			bash.Run([]string{`systemctl`, `disable`, `--now`, `stubby`}, "", nil)
+		}
+	}
+
+	if out, err := bash.Run([]string{`stubby`, `-i`, `-C`, stubbyConfigPath}, "", nil); err != nil {
+		restore()
+		return fmt.Errorf("invalid config: %s", strings.TrimSpace(string(out)))
+	}
+
+	bash.Run([]string{`systemctl`, `enable`, `stubby`}, "", nil)
+	bash.Run([]string{`systemctl`, `restart`, `stubby`}, "", nil)
+
+	if err := queryDNSServer("127.0.0.1"); err != nil {
+		restore()
+		return fmt.Errorf("test lookup failed: %s", err)
+	}
+
+	return nil
+}
+
+// setSystemDNS points the system at the given nameservers, through NetworkManager if it manages dns
+//
+// returns a function that restores the previous nameservers
+func setSystemDNS(ips []string) func() {
+	ipv4 := []string{}
+	ipv6 := []string{}
+	for _, ip := range ips {
+		if strings.Contains(ip, ":") {
+			ipv6 = append(ipv6, ip)
+		} else {
+			ipv4 = append(ipv4, ip)
+		}
+	}
+
+	if serviceActive("NetworkManager") {
+		dnsFields := []string{`ipv4.dns`, `ipv4.ignore-auto-dns`, `ipv6.dns`, `ipv6.ignore-auto-dns`}
+		restore := [][]string{}
+
+		out, _ := bash.Run([]string{`nmcli`, `-t`, `-f`, `NAME,DEVICE`, `connection`, `show`, `--active`}, "", nil)
+		for _, line := range strings.Split(string(out), "\n") {
+			name, device, ok := strings.Cut(line, ":")
+			if !ok || device == "lo" {
+				continue
+			}
+
+			if out, err := bash.Run([]string{`nmcli`, `-g`, strings.Join(dnsFields, ","), `connection`, `show`, name}, "", nil); err == nil {
+				if vals := strings.Split(strings.TrimRight(string(out), "\n"), "\n"); len(vals) == len(dnsFields) {
+					args := []string{`nmcli`, `connection`, `modify`, name}
+					for i, field := range dnsFields {
+						args = append(args, field, vals[i])
+					}
+					restore = append(restore, args, []string{`nmcli`, `device`, `reapply`, device})
+				}
+			}
+
+			args := []string{`nmcli`, `connection`, `modify`, name}
+			if len(ipv4) != 0 {
+				args = append(args, `ipv4.dns`, strings.Join(ipv4, ","), `ipv4.ignore-auto-dns`, `yes`)
+			}
+			if len(ipv6) != 0 {
+				args = append(args, `ipv6.dns`, strings.Join(ipv6, ","), `ipv6.ignore-auto-dns`, `yes`)
+			}
+
+			bash.Run(args, "", nil)
+			bash.Run([]string{`nmcli`, `device`, `reapply`, device}, "", nil)
+		}
+
+		return func() {
+			for _, args := range restore {
+				bash.Run(args, "", nil)
+			}
+		}
+	}
+
+	// resolv.conf only uses the first 3 nameservers
+	if len(ips) > 3 {
+		ips = ips[:3]
+	}
+
+	conf := "# generated by Special Modifications\n"
+	for _, ip := range ips {
+		conf += "nameserver " + ip + "\n"
+	}
+	conf += "options edns0 trust-ad\n"
+
+	link, linkErr := os.Readlink("/etc/resolv.conf")
+	previous, prevErr := os.ReadFile("/etc/resolv.conf")
+	if prevErr == nil {
+		os.WriteFile("/etc/resolv.conf.bak", previous, 0644)
+	}
+
+	// replace a symlink to a generated file, so it is not overwritten
+	os.Remove("/etc/resolv.conf")
+	os.WriteFile("/etc/resolv.conf", []byte(conf), 0644)
+
+	return func() {
+		if linkErr == nil {
+			os.Remove("/etc/resolv.conf")
+			os.Symlink(link, "/etc/resolv.conf")
+		} else if prevErr == nil {
+			os.WriteFile("/etc/resolv.conf", previous, 0644)
+		}
+	}
+}
+
+type dnsCheck struct {
+	network  bool   // a configured dns server answered a plain query
+	resolves bool   // names resolve through the system resolver
+	dot      bool   // the DNS over TLS port is reachable
+	status   string // protocols reported by resolvectl status, or the dns backend
+	fallback []string
+}
+
+// verifyDNS tests the resolver, and downgrades DoT or DNSSEC only when they are the cause of a failure
+func verifyDNS(opts *config) *dnsCheck {
+	check := &dnsCheck{fallback: []string{}}
+
+	servers := append(dnsServers(opts, "dnsProvider"), dnsServers(opts, "dnsFallback")...)
+	for _, server := range servers {
+		ip, _, _ := strings.Cut(server, "#")
+
+		if !check.network && queryDNSServer(ip) == nil {
+			check.network = true
+		}
+
+		if !check.dot {
+			if conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, "853"), 5*time.Second); err == nil {
+				conn.Close()
+				check.dot = true
+			}
+		}
+	}
+
+	if backend := opts.value("dnsBackend"); backend != "" && backend != "resolved" {
+		check.resolves = systemResolves()
+		check.status = backend
+		return check
+	}
+
+	if !check.network {
+		// nothing to fix in the dns config if the servers cannot be reached at all
+		check.status = resolvedStatus()
+		return check
+	}
+
+	if check.resolves = resolvedQuery(); !check.resolves && !check.dot {
+		setResolvedOption("DNSOverTLS", "opportunistic")
+		check.fallback = append(check.fallback, "DNSOverTLS=opportunistic (port 853 is blocked)")
+		check.resolves = resolvedQuery()
+	}
+
+	if !check.resolves && resolvedQuery("--validate=no") {
+		setResolvedOption("DNSSEC", "allow-downgrade")
+		check.fallback = append(check.fallback, "DNSSEC=allow-downgrade (validation failed)")
+
+		if check.resolves = resolvedQuery(); !check.resolves {
+			setResolvedOption("DNSSEC", "no")
+			check.fallback = append(check.fallback, "DNSSEC=no (validation failed with downgrade)")
+			check.resolves = resolvedQuery()
+		}
+	}
+
+	check.status = resolvedStatus()
+	return check
+}
+
+func (check *dnsCheck) report() string {
+	report := []string{}
+
+	if check.network {
+		report = append(report, "Network: DNS servers are reachable")
+	} else {
+		report = append(report, "Network: DNS servers are unreachable (network may be down, DNS config was left unchanged)")
+	}
+
+	if check.dot {
+		report = append(report, "DNS over TLS: port 853 is reachable")
+	} else {
+		report = append(report, "DNS over TLS: port 853 is unreachable")
+	}
+
+	if check.resolves {
+		report = append(report, "Resolver: OK")
+	} else {
+		report = append(report, "Resolver: FAILED")
+	}
+
+	if check.status != "" {
+		report = append(report, "Status: "+check.status)
+	}
+
+	if len(check.fallback) == 0 {
+		report = append(report, "Fallback: none")
+	} else {
+		for _, fallback := range check.fallback {
+			report = append(report, "Fallback: "+fallback)
+		}
+	}
+
+	return strings.Join(report, "\n")
+}
+
+// queryDNSServer resolves a name directly against a dns server, bypassing the system resolver
+func queryDNSServer(ip string) error {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: 5 * time.Second}
+			return dialer.DialContext(ctx, "udp", net.JoinHostPort(ip, "53"))
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := resolver.LookupHost(ctx, "example.com")
+	return err
+}
+
+func resolvedQuery(args ...string) bool {
+	_, err := bash.Run(append(append([]string{`resolvectl`, `query`, `--cache=no`}, args...), `example.com`), "", nil)
+	return err == nil
+}
+
+// resolvedStatus returns the global protocols line from resolvectl (example: "+DNSOverTLS DNSSEC=yes/supported")
+func resolvedStatus() string {
+	if out, err := bash.Run([]string{`resolvectl`, `status`}, "", nil); err == nil {
+		for _, line := range strings.Split(string(out), "\n") {
+			if _, val, ok := strings.Cut(line, "Protocols:"); ok {
+				return strings.TrimSpace(val)
+			}
+		}
+	}
+	return ""
+}
+
+func setResolvedOption(key string, val string) {
+	if buf, err := os.ReadFile(resolvedDropInPath); err == nil {
+		buf = regex.Comp(`(?m)^#?%1=.*$`, key).Rep(buf, []byte(key+"="+val))
+		os.WriteFile(resolvedDropInPath, buf, 0644)
+	}
+
+	bash.Run([]string{`systemctl`, `restart`, `systemd-resolved`}, "", nil)
+	bash.Run([]string{`resolvectl`, `flush-caches`}, "", nil)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+// generatedAssets are rewritten by their step after the files are installed, so they are checked by that step instead
+var generatedAssets = map[string]bool{
+	sysctlDropInPath: true,
+}
+
+type driftItem struct {
+	name   string
+	detail string
+	fix    func()
+}
+
+// saveApplied keeps the config of the last core install, to check the system against it later
+func saveApplied(opts *config) {
+	if buf, err := goutil.JSON.Stringify(opts.values); err == nil {
+		os.MkdirAll(stateDir, 0700)
+		os.WriteFile(stateDir+"/applied.json", buf, 0600)
+	}
+}
+
+func loadApplied() *config {
+	buf, err := os.ReadFile(stateDir + "/applied.json")
+	if err != nil {
+		return nil
+	}
+
+	json, err := goutil.JSON.Parse(buf)
+	if err != nil {
+		return nil
+	}
+
+	opts := newConfig()
+	for key, val := range json {
+		if v, ok := val.(string); ok {
+			opts.setValue(key, v)
+		}
+	}
+	return opts
+}
+
+// detectDrift compares the system with what the core install would set with the applied config
+func detectDrift(opts *config) []*driftItem {
+	drift := []*driftItem{}
+	drift = append(drift, driftFiles("", assetPerms())...)
+	drift = append(drift, driftPackages()...)
+	drift = append(drift, driftFirewall(opts)...)
+	drift = append(drift, driftDNS(opts)...)
+	drift = append(drift, driftSSH(opts)...)
+	drift = append(drift, driftSysctl(opts)...)
+	drift = append(drift, driftPassword(opts)...)
+	drift = append(drift, driftServices(opts)...)
+	drift = append(drift, driftUpdates(opts)...)
+	return drift
+}
+
+// driftFiles compares the installed files with assets/fs
+func driftFiles(dir string, filePerms map[string]os.FileMode) []*driftItem {
+	drift := []*driftItem{}
+
+	files, err := assetFS.ReadDir("assets/fs" + dir)
+	if err != nil {
+		return drift
+	}
+
+	for _, file := range files {
+		path := dir + "/" + file.Name()
+		if strings.HasPrefix(path, "/.") {
+			continue
+		}
+
+		if file.IsDir() {
+			drift = append(drift, driftFiles(path, filePerms)...)
+			continue
+		} else if generatedAssets[path] {
+			continue
+		}
+
+		// the kernel step sets installonly_limit in dnf.conf, readAsset fills it in
+		buf, err := readAsset(path)
+		if err != nil {
+			continue
+		}
+
+		var perm os.FileMode = 0644
+		if val, ok := filePerms[path]; ok {
+			perm = val
+		}
+
+		fix := func() {
+			os.MkdirAll(dir, 0755)
+			os.WriteFile(path, buf, perm)
+			os.Chmod(path, perm)
+		}
+
+		if stat, err := os.Stat(path); err != nil {
+			drift = append(drift, &driftItem{name: path, detail: "file is missing", fix: fix})
+		} else if current, err := os.ReadFile(path); err == nil && !bytes.Equal(current, buf) {
+			drift = append(drift, &driftItem{name: path, detail: "file content changed", fix: fix})
+		} else if stat.Mode().Perm() != perm {
+			drift = append(drift, &driftItem{name: path, detail: fmt.Sprintf("mode is %o, expected %o", stat.Mode().Perm(), perm), fix: fix})
+		}
+	}
+
+	return drift
+}
+
+func driftPackages() []*driftItem {
+	pkgs := []string{`fail2ban`, `clamav`, `rkhunter`}
+	if PM == "apt" {
+		pkgs = append(pkgs, `clamav-daemon`, `libpam-pwquality`, `unattended-upgrades`)
+	} else if PM == "dnf" {
+		pkgs = append(pkgs, `clamd`, `dnf-automatic`)
+	}
+
+	missing := []string{}
+	for _, pkg := range pkgs {
+		if !hasPKG(pkg) {
+			missing = append(missing, pkg)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return []*driftItem{{name: "packages", detail: "not installed: " + strings.Join(missing, ", "), fix: func() {
+		installPKG(missing...)
+	}}}
+}
+
+func driftFirewall(opts *config) []*driftItem {
+	name := opts.value("firewall")
+	if name == "" {
+		name = "ufw"
+	}
+
+	fw := newFirewall(name)
+	policy := firewallPolicyFor(opts)
+
+	fix := func() {
+		setupFirewall(opts, name, policy, func(fw firewall) {
+			fw.reset()
+		})
+	}
+
+	if !goutil.Contains(activeFirewalls(), name) {
+		return []*driftItem{{name: "firewall", detail: name + " is not enabled", fix: fix}}
+	}
+
+	changed := []string{}
+
+	incoming, ports := fw.status()
+	if incoming != policy.incoming {
+		changed = append(changed, "incoming is "+incoming+", expected "+policy.incoming)
+	}
+
+	for _, rule := range policy.rules {
+		if !goutil.Contains(ports, rule.port+"/"+rule.proto) {
+			changed = append(changed, rule.port+"/"+rule.proto+" ("+rule.name+") is not allowed")
+		}
+	}
+
+	// the ssh ports are allowed by guardSSH when the firewall is set up over ssh
+	for _, port := range ports {
+		p, proto, _ := strings.Cut(port, "/")
+		if !policy.allows(p, proto) && !(proto == "tcp" && goutil.Contains(sshPorts(), p)) {
+			changed = append(changed, port+" is allowed, but not in the "+opts.value("firewallProfile")+" profile")
+		}
+	}
+
+	if len(changed) != 0 {
+		return []*driftItem{{name: "firewall", detail: strings.Join(changed, ", "), fix: fix}}
+	}
+	return nil
+}
+
+func driftDNS(opts *config) []*driftItem {
+	fix := func() {
+		secureDNS(opts)
+	}
+
+	switch opts.value("dnsBackend") {
+	case "resolved":
+		expected := resolvedConf(opts)
+
+		// options downgraded by the dns test are expected to differ
+		if buf, err := os.ReadFile(stateDir + "/dns-check.txt"); err == nil {
+			for _, line := range strings.Split(string(buf), "\n") {
+				if fallback, ok := strings.CutPrefix(line, "Fallback: "); ok {
+					if key, val, ok := strings.Cut(strings.SplitN(fallback, " ", 2)[0], "="); ok {
+						expected = replaceConfLine(expected, key, key+"="+val)
+					}
+				}
+			}
+		}
+
+		if buf, err := os.ReadFile(resolvedDropInPath); err != nil {
+			return []*driftItem{{name: resolvedDropInPath, detail: "file is missing", fix: fix}}
+		} else if string(buf) != expected {
+			return []*driftItem{{name: resolvedDropInPath, detail: "file content changed", fix: fix}}
+		}
+	case "stubby":
+		if !serviceActive("stubby") {
+			return []*driftItem{{name: "stubby", detail: "service is not running", fix: fix}}
+		}
+	}
+
+	return nil
+}
+
+// replaceConfLine replaces a key=value line in a config file
+func replaceConfLine(conf string, key string, line string) string {
+	lines := strings.Split(conf, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, key+"=") {
+			lines[i] = line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func driftSSH(opts *config) []*driftItem {
+	service := sshService()
+
+	if opts.bool("disableSSH") {
+		if serviceActive(service) || serviceActive(service+".socket") {
+			return []*driftItem{{name: "ssh", detail: service + " is running, but was disabled", fix: disableSSH}}
+		}
+		return nil
+	}
+
+	if !opts.bool("hardenSSH") {
+		return nil
+	}
+
+	out, err := bash.Run([]string{`sshd`, `-T`}, "", nil)
+	if err != nil {
+		return []*driftItem{{name: "ssh", detail: "invalid sshd config", fix: hardenSSH}}
+	}
+
+	current := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if key, val, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			current[key] = val
+		}
+	}
+
+	keyAuth := hasAuthorizedKeys()
+	changed := []string{}
+	for _, opt := range sshdHardening {
+		if !keyAuth && (opt[0] == "PasswordAuthentication" || opt[0] == "KbdInteractiveAuthentication") {
+			continue
+		}
+
+		val := current[strings.ToLower(opt[0])]
+		if opt[0] == "PermitRootLogin" && (val == "prohibit-password" || val == "without-password") {
+			continue
+		}
+
+		if val != opt[1] {
+			changed = append(changed, opt[0]+"="+val)
+		}
+	}
+
+	if len(changed) != 0 {
+		return []*driftItem{{name: "ssh", detail: "changed: " + strings.Join(changed, ", "), fix: hardenSSH}}
+	}
+	return nil
+}
+
+func driftSysctl(opts *config) []*driftItem {
+	fix := func() {
+		hardenSysctl(opts)
+	}
+
+	conf, active, _ := sysctlConf(opts)
+	if buf, err := os.ReadFile(sysctlDropInPath); err != nil || string(buf) != conf {
+		return []*driftItem{{name: sysctlDropInPath, detail: "file content changed", fix: fix}}
+	}
+
+	changed := []string{}
+	for _, setting := range active {
+		if out, err := bash.Run([]string{`sysctl`, `-n`, setting.key}, "", nil); err != nil || strings.Join(strings.Fields(string(out)), " ") != setting.val {
+			changed = append(changed, setting.key)
+		}
+	}
+
+	if len(changed) != 0 {
+		return []*driftItem{{name: "sysctl", detail: "changed: " + strings.Join(changed, ", "), fix: fix}}
+	}
+	return nil
+}
+
+func driftPassword(opts *config) []*driftItem {
+	fix := func() {
+		setPasswordPolicy(opts)
+	}
+
+	if buf, err := os.ReadFile(pwqualityDropInPath); err != nil || string(buf) != pwqualityConf(opts) {
+		return []*driftItem{{name: pwqualityDropInPath, detail: "password policy changed", fix: fix}}
+	}
+
+	conf := readPwqualityConfig(pwqualityPath)
+	for _, opt := range passwordPolicies["strict"] {
+		if conf.comment(opt[0]) {
+			return []*driftItem{{name: pwqualityPath, detail: opt[0] + " is set outside the drop-in", fix: fix}}
+		}
+	}
+	return nil
+}
+
+func driftServices(opts *config) []*driftItem {
+	drift := []*driftItem{}
+
+	if !serviceActive("fail2ban") {
+		drift = append(drift, &driftItem{name: "fail2ban", detail: "service is not running", fix: func() {
+			installFail2ban(opts)
+		}})
+	} else if buf, err := os.ReadFile(fail2banJailDir + "/special-modifications-defaults.local"); err != nil || !strings.Contains(string(buf), "bantime = "+opts.value("fail2banBantime")+"\n") {
+		drift = append(drift, &driftItem{name: "fail2ban", detail: "jail defaults changed", fix: func() {
+			installFail2ban(opts)
+		}})
+	}
+
+	daemon, onacc := clamavServices()
+	stopped := []string{}
+	for _, service := range []string{daemon, onacc, "clamav-freshclam"} {
+		if !serviceActive(service) {
+			stopped = append(stopped, service)
+		}
+	}
+	if len(stopped) != 0 {
+		drift = append(drift, &driftItem{name: "clamav", detail: "not running: " + strings.Join(stopped, ", "), fix: configureClamd})
+	}
+
+	for _, schedule := range loadScanSchedules() {
+		if !serviceActive(schedule.unit() + ".timer") {
+			drift = append(drift, &driftItem{name: schedule.unit() + ".timer", detail: "timer is not active", fix: func() {
+				schedule.install()
+			}})
+		}
+	}
+
+	if buf, err := os.ReadFile(rkhunterConfLocal); err != nil || !strings.Contains(string(buf), `WEB_CMD=""`) {
+		drift = append(drift, &driftItem{name: rkhunterConfLocal, detail: "config changed", fix: func() {
+			writeRkhunterConfig()
+		}})
+	}
+
+	if !serviceActive(rkhunterUnit + ".timer") {
+		drift = append(drift, &driftItem{name: rkhunterUnit + ".timer", detail: "timer is not active", fix: func() {
+			scheduleRkhunter(opts.value("rkhunterSchedule"))
+		}})
+	}
+
+	return drift
+}
+
+func driftUpdates(opts *config) []*driftItem {
+	if opts.value("updatePolicy") == "off" {
+		return nil
+	}
+
+	for _, timer := range updateTimers() {
+		if !serviceActive(timer) {
+			return []*driftItem{{name: timer, detail: "timer is not active", fix: func() {
+				configureUpdates(opts)
+			}}}
+		}
+	}
+	return nil
+}
+
+// checkCLI handles the `check [--fix]` command
+//
+// exit codes: 0 no drift, 1 drift found, 3 nothing has been applied yet
+func checkCLI() {
+	opts := loadApplied()
+	if opts == nil {
+		fmt.Println("No applied config found, run --core first")
+		os.Exit(3)
+	}
+
+	drift := detectDrift(opts)
+
+	if cliArgs["fix"] == "true" && len(drift) != 0 {
+		for _, item := range drift {
+			fmt.Println("Fixing " + item.name + ": " + item.detail)
+			item.fix()
+		}
+		drift = detectDrift(opts)
+	}
+
+	if len(drift) == 0 {
+		fmt.Println("OK: no drift from the applied config")
+		return
+	}
+
+	for _, item := range drift {
+		fmt.Println("DRIFT: " + item.name + ": " + item.detail)
+	}
+	os.Exit(1)
+}
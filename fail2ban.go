@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+)
+
+const fail2banJailDir = "/etc/fail2ban/jail.d"
+
+type fail2banService struct {
+	name   string
+	detect []string // commands that show the service is installed
+	jails  []string
+}
+
+var fail2banServices = []fail2banService{
+	{name: "sshd", detect: []string{"sshd"}, jails: []string{"sshd"}},
+	{name: "nginx", detect: []string{"nginx"}, jails: []string{"nginx-http-auth", "nginx-botsearch"}},
+	{name: "apache", detect: []string{"apache2", "httpd"}, jails: []string{"apache-auth", "apache-botsearch"}},
+	{name: "postfix", detect: []string{"postfix"}, jails: []string{"postfix", "postfix-sasl"}},
+	{name: "dovecot", detect: []string{"dovecot"}, jails: []string{"dovecot"}},
+}
+
+// fail2ban ban actions for each firewall backend (banaction, banaction_allports)
+var fail2banActions = map[string][2]string{
+	"ufw":       {"ufw", "ufw"},
+	"firewalld": {"firewallcmd-rich-rules", "firewallcmd-allports"},
+	"nftables":  {"nftables-multiport", "nftables-allports"},
+}
+
+func fail2banConfig(opts *config) {
+	opts.addValue("fail2banBantime", "How long should fail2ban ban an IP address (default: 1h)?", "1h")
+	opts.addValue("fail2banFindtime", "How long should fail2ban count failed attempts for (default: 10m)?", "10m")
+	opts.addValue("fail2banMaxretry", "How many failed attempts are allowed before a ban (default: 5)?", "5")
+}
+
+// detectFail2banServices returns the services installed on the system that fail2ban can protect
+func detectFail2banServices(opts *config) []fail2banService {
+	services := []fail2banService{}
+
+	for _, service := range fail2banServices {
+		if service.name == "sshd" && opts.bool("disableSSH") {
+			continue
+		}
+
+		for _, cmd := range service.detect {
+			if out, err := bash.Run([]string{`which`, cmd}, "", nil); err == nil && len(out) != 0 {
+				services = append(services, service)
+				break
+			}
+		}
+	}
+
+	return services
+}
+
+func installFail2ban(opts *config) {
+	installPKG(`fail2ban`)
+	if PM == "apt" {
+		installPKG(`python3-systemd`)
+	}
+
+	actions, ok := fail2banActions[opts.value("firewall")]
+	if !ok {
+		actions = fail2banActions["ufw"]
+	}
+
+	// keep the current drop-ins, to restore them if the new config is invalid
+	backup := map[string][]byte{}
+	if files, err := filepath.Glob(fail2banJailDir + "/special-modifications-*.local"); err == nil {
+		for _, file := range files {
+			if buf, err := os.ReadFile(file); err == nil {
+				backup[file] = buf
+			}
+			os.Remove(file)
+		}
+	}
+
+	os.MkdirAll(fail2banJailDir, 0755)
+
+	os.WriteFile(fail2banJailDir+"/special-modifications-defaults.local", []byte(`# generated by Special Modifications
+[DEFAULT]
+ignoreip = 127.0.0.1/8 ::1
+bantime = `+opts.value("fail2banBantime")+`
+findtime = `+opts.value("fail2banFindtime")+`
+maxretry = `+opts.value("fail2banMaxretry")+`
+banaction = `+actions[0]+`
+banaction_allports = `+actions[1]+`
+`), 0644)
+
+	for _, service := range detectFail2banServices(opts) {
+		jails := []string{"# generated by Special Modifications"}
+
+		for _, jail := range service.jails {
+			jails = append(jails, "", "["+jail+"]", "enabled = true")
+
+			if jail == "sshd" {
+				jails = append(jails, "port = "+strings.Join(sshPorts(), ","), "backend = systemd")
+			}
+		}
+
+		os.WriteFile(fail2banJailDir+"/special-modifications-"+service.name+".local", []byte(strings.Join(jails, "\n")+"\n"), 0644)
+		fmt.Println("Fail2Ban: protecting " + service.name)
+	}
+
+	if out, err := bash.Run([]string{`fail2ban-client`, `-t`}, "", nil); err != nil {
+		fmt.Println("Invalid fail2ban config, keeping the previous jails:", strings.TrimSpace(string(out)))
+
+		if files, err := filepath.Glob(fail2banJailDir + "/special-modifications-*.local"); err == nil {
+			for _, file := range files {
+				os.Remove(file)
+			}
+		}
+		for file, buf := range backup {
+			os.WriteFile(file, buf, 0644)
+		}
+		return
+	}
+
+	bash.Run([]string{`systemctl`, `enable`, `fail2ban`}, "", nil)
+	bash.Run([]string{`systemctl`, `restart`, `fail2ban`}, "", nil)
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+type firewallRule struct {
+	name  string
+	port  string // a port or range (example: "22", "1714-1764")
+	proto string // "tcp" or "udp"
+	limit bool   // rate limit new connections
+}
+
+type firewallPolicy struct {
+	// incoming sets how unmatched incoming traffic is handled
+	//
+	// "deny" rejects the connection, "drop" silently ignores it
+	incoming string
+
+	rules []firewallRule
+}
+
+type firewall interface {
+	install()
+	reset()
+
+	// apply writes the policy, it returns an error when the rules could not be applied
+	apply(policy *firewallPolicy) error
+	enable()
+	disable()
+
+	// status returns the incoming policy ("deny" or "drop") and the allowed ports (example: "22/tcp", "1714-1764/udp")
+	status() (string, []string)
+}
+
+func newFirewall(name string) firewall {
+	switch name {
+	case "firewalld":
+		return &firewalldFirewall{}
+	case "nftables":
+		return &nftablesFirewall{}
+	default:
+		return &ufwFirewall{}
+	}
+}
+
+// disableFirewalls disables every firewall backend except the one in use
+func disableFirewalls(keep string) {
+	for _, name := range []string{"ufw", "firewalld", "nftables"} {
+		if name != keep {
+			newFirewall(name).disable()
+		}
+	}
+}
+
+// activeFirewalls returns the firewall backends that are currently enabled
+func activeFirewalls() []string {
+	active := []string{}
+
+	if out, err := bash.Run([]string{`ufw`, `status`}, "", nil); err == nil && strings.Contains(string(out), "Status: active") {
+		active = append(active, "ufw")
+	}
+
+	if serviceActive("firewalld") {
+		active = append(active, "firewalld")
+	}
+
+	if _, err := bash.Run([]string{`nft`, `list`, `table`, `inet`, `special_modifications`}, "", nil); err == nil {
+		active = append(active, "nftables")
+	}
+
+	return active
+}
+
+// firewallServices are the named services that can be allowed through the firewall
+var firewallServices = map[string][]firewallRule{
+	"dhcpv6-client": {{name: "dhcpv6-client", port: "546", proto: "udp"}},
+	"mdns":          {{name: "mdns", port: "5353", proto: "udp"}},
+	"kdeconnect": {
+		{name: "kdeconnect", port: "1714-1764", proto: "tcp"},
+		{name: "kdeconnect", port: "1714-1764", proto: "udp"},
+	},
+	"syncthing": {
+		{name: "syncthing", port: "22000", proto: "tcp"},
+		{name: "syncthing", port: "22000", proto: "udp"},
+		{name: "syncthing-discovery", port: "21027", proto: "udp"},
+	},
+	"printing": {
+		{name: "ipp", port: "631", proto: "tcp"},
+		{name: "ipp", port: "631", proto: "udp"},
+	},
+	"samba": {
+		{name: "samba", port: "139", proto: "tcp"},
+		{name: "samba", port: "445", proto: "tcp"},
+		{name: "netbios", port: "137-138", proto: "udp"},
+	},
+	"ssh":   {{name: "ssh", port: "22", proto: "tcp", limit: true}},
+	"http":  {{name: "http", port: "80", proto: "tcp"}},
+	"https": {{name: "https", port: "443", proto: "tcp"}},
+	"dns": {
+		{name: "dns", port: "53", proto: "tcp"},
+		{name: "dns", port: "53", proto: "udp"},
+	},
+	"dev-server": {
+		{name: "dev-server", port: "3000", proto: "tcp"},
+		{name: "dev-server", port: "8000", proto: "tcp"},
+		{name: "dev-server", port: "8080", proto: "tcp"},
+	},
+}
+
+var firewallProfiles = map[string][]string{
+	"desktop":   {"dhcpv6-client", "mdns", "kdeconnect", "syncthing", "printing"},
+	"developer": {"dhcpv6-client", "mdns", "kdeconnect", "syncthing", "printing", "dev-server"},
+	"server":    {"dhcpv6-client", "ssh", "http", "https"},
+	"paranoid":  {},
+}
+
+func firewallConfig(opts *config) {
+	opts.addSelect("firewallProfile", "Which firewall profile would you like to use?", "desktop", "developer", "server", "paranoid")
+	opts.addValue("firewallAllow", "Allow any other services or ports (example: ssh samba 8080/tcp 51820/udp)?", "")
+
+	if SSHClient {
+		opts.addValue("firewallRollback", "How many seconds should you have to confirm the firewall still allows SSH (default: 60)?", "60")
+	}
+
+	fmt.Println("Firewall Profile: " + opts.value("firewallProfile"))
+	printFirewallPolicy(firewallPolicyFor(opts))
+}
+
+func firewallPolicyFor(opts *config) *firewallPolicy {
+	profile := opts.value("firewallProfile")
+	if _, ok := firewallProfiles[profile]; !ok {
+		profile = "desktop"
+	}
+
+	policy := &firewallPolicy{incoming: "deny"}
+	if profile == "paranoid" {
+		policy.incoming = "drop"
+	}
+
+	for _, name := range firewallProfiles[profile] {
+		policy.add(firewallServices[name]...)
+	}
+
+	for _, name := range strings.FieldsFunc(opts.value("firewallAllow"), func(r rune) bool {
+		return r == ' ' || r == ','
+	}) {
+		if rules, ok := firewallServices[name]; ok {
+			policy.add(rules...)
+			continue
+		}
+
+		port, proto, _ := strings.Cut(name, "/")
+		if proto == "" {
+			proto = "tcp"
+		}
+
+		if !validPortRange(port) || (proto != "tcp" && proto != "udp") {
+			fmt.Println("Ignoring invalid firewall rule: " + name)
+			continue
+		}
+
+		policy.add(firewallRule{name: "custom", port: port, proto: proto})
+	}
+
+	// keep ssh closed when it was disabled
+	if opts.bool("disableSSH") {
+		rules := []firewallRule{}
+		for _, rule := range policy.rules {
+			if rule.name != "ssh" {
+				rules = append(rules, rule)
+			}
+		}
+		policy.rules = rules
+	}
+
+	return policy
+}
+
+// validPortRange checks a port or range (example: "22", "1714-1764") is within 1-65535
+func validPortRange(port string) bool {
+	m := regex.Comp(`^([0-9]+)(?:-([0-9]+))?$`).RE.FindStringSubmatch(port)
+	if m == nil {
+		return false
+	}
+
+	low, err := strconv.Atoi(m[1])
+	if err != nil {
+		return false
+	}
+
+	high := low
+	if m[2] != "" {
+		if high, err = strconv.Atoi(m[2]); err != nil {
+			return false
+		}
+	}
+
+	return low >= 1 && high <= 65535 && low <= high
+}
+
+// add appends rules that are not already allowed by the policy
+func (policy *firewallPolicy) add(rules ...firewallRule) {
+	for _, rule := range rules {
+		if !policy.allows(rule.port, rule.proto) {
+			policy.rules = append(policy.rules, rule)
+		}
+	}
+}
+
+func (policy *firewallPolicy) allows(port string, proto string) bool {
+	for _, rule := range policy.rules {
+		if rule.port == port && rule.proto == proto {
+			return true
+		}
+	}
+	return false
+}
+
+func printFirewallPolicy(policy *firewallPolicy) {
+	fmt.Println("Incoming: " + policy.incoming + " (unless allowed below)")
+	fmt.Println("Outgoing: allow")
+
+	if len(policy.rules) == 0 {
+		fmt.Println("Open Ports: none")
+		return
+	}
+
+	fmt.Println("Open Ports:")
+	for _, rule := range policy.rules {
+		if rule.limit {
+			fmt.Println("  " + rule.port + "/" + rule.proto + " (" + rule.name + ", rate limited)")
+		} else {
+			fmt.Println("  " + rule.port + "/" + rule.proto + " (" + rule.name + ")")
+		}
+	}
+}
+
+//* ufw
+
+type ufwFirewall struct{}
+
+func (fw *ufwFirewall) install() {
+	installPKG("ufw")
+}
+
+func (fw *ufwFirewall) reset() {
+	bash.Run([]string{`ufw`, `--force`, `reset`}, "", nil)
+}
+
+func (fw *ufwFirewall) apply(policy *firewallPolicy) error {
+	// ufw calls dropping "deny", and rejecting "reject"
+	incoming := "reject"
+	if policy.incoming == "drop" {
+		incoming = "deny"
+	}
+
+	if out, err := bash.Run([]string{`ufw`, `default`, incoming, `incoming`}, "", nil); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	if out, err := bash.Run([]string{`ufw`, `default`, `allow`, `outgoing`}, "", nil); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+
+	for _, rule := range policy.rules {
+		action := "allow"
+		if rule.limit {
+			action = "limit"
+		}
+
+		if out, err := bash.Run([]string{`ufw`, action, strings.ReplaceAll(rule.port, "-", ":") + "/" + rule.proto, `comment`, rule.name}, "", nil); err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
+
+func (fw *ufwFirewall) enable() {
+	disableFirewalls("ufw")
+	bash.Run([]string{`systemctl`, `enable`, `--now`, `ufw`}, "", nil)
+	bash.Run([]string{`ufw`, `--force`, `enable`}, "", nil)
+}
+
+func (fw *ufwFirewall) disable() {
+	if out, err := bash.Run([]string{`which`, `ufw`}, "", nil); err == nil && len(out) != 0 {
+		bash.Run([]string{`ufw`, `disable`}, "", nil)
+	}
+	bash.Run([]string{`systemctl`, `disable`, `--now`, `ufw`}, "", nil)
+}
+
+func (fw *ufwFirewall) status() (string, []string) {
+	out, err := bash.Run([]string{`ufw`, `status`, `verbose`}, "", nil)
+	if err != nil {
+		return "", nil
+	}
+
+	incoming := ""
+	ports := []string{}
+	rules := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if m := regex.Comp(`Default: (\w+) \(incoming\)`).RE.FindStringSubmatch(line); m != nil {
+			// ufw calls dropping "deny", and rejecting "reject"
+			switch m[1] {
+			case "reject":
+				incoming = "deny"
+			case "deny":
+				incoming = "drop"
+			default:
+				incoming = m[1]
+			}
+		} else if strings.HasPrefix(line, "--") {
+			rules = true
+		} else if fields := strings.Fields(line); rules && len(fields) != 0 && strings.Contains(fields[0], "/") {
+			if port := strings.ReplaceAll(fields[0], ":", "-"); !goutil.Contains(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+	}
+
+	return incoming, ports
+}
+
+//* firewalld
+
+type firewalldFirewall struct {
+	zone string
+}
+
+func (fw *firewalldFirewall) install() {
+	installPKG("firewalld")
+}
+
+// run uses firewall-cmd while firewalld is running, and firewall-offline-cmd before it is enabled
+func (fw *firewalldFirewall) run(args ...string) ([]byte, error) {
+	if serviceActive("firewalld") {
+		return bash.Run(append([]string{`firewall-cmd`}, args...), "", nil)
+	}
+
+	// the offline command only changes the permanent config
+	offline := []string{`firewall-offline-cmd`}
+	for _, arg := range args {
+		if arg != "--permanent" {
+			offline = append(offline, arg)
+		}
+	}
+	return bash.Run(offline, "", nil)
+}
+
+func (fw *firewalldFirewall) reset() {
+	for _, zone := range []string{"public", "drop"} {
+		if out, err := fw.run(`--permanent`, `--zone=`+zone, `--list-services`); err == nil {
+			for _, service := range strings.Fields(string(out)) {
+				fw.run(`--permanent`, `--zone=`+zone, `--remove-service=`+service)
+			}
+		}
+
+		if out, err := fw.run(`--permanent`, `--zone=`+zone, `--list-ports`); err == nil {
+			for _, port := range strings.Fields(string(out)) {
+				fw.run(`--permanent`, `--zone=`+zone, `--remove-port=`+port)
+			}
+		}
+
+		if out, err := fw.run(`--permanent`, `--zone=`+zone, `--list-rich-rules`); err == nil {
+			for _, rule := range strings.Split(string(out), "\n") {
+				if rule = strings.TrimSpace(rule); rule != "" {
+					fw.run(`--permanent`, `--zone=`+zone, `--remove-rich-rule=`+rule)
+				}
+			}
+		}
+	}
+}
+
+func (fw *firewalldFirewall) apply(policy *firewallPolicy) error {
+	fw.zone = "public"
+	if policy.incoming == "drop" {
+		fw.zone = "drop"
+	}
+
+	args := [][]string{{`--set-default-zone=` + fw.zone}}
+	for _, rule := range policy.rules {
+		if rule.limit {
+			args = append(args,
+				[]string{`--permanent`, `--zone=` + fw.zone, `--add-rich-rule=rule family="ipv4" port port="` + rule.port + `" protocol="` + rule.proto + `" accept limit value="6/m"`},
+				[]string{`--permanent`, `--zone=` + fw.zone, `--add-rich-rule=rule family="ipv6" port port="` + rule.port + `" protocol="` + rule.proto + `" accept limit value="6/m"`},
+			)
+		} else {
+			args = append(args, []string{`--permanent`, `--zone=` + fw.zone, `--add-port=` + rule.port + "/" + rule.proto})
+		}
+	}
+
+	if serviceActive("firewalld") {
+		args = append(args, []string{`--reload`})
+	}
+
+	for _, arg := range args {
+		if out, err := fw.run(arg...); err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
+
+func (fw *firewalldFirewall) enable() {
+	disableFirewalls("firewalld")
+	bash.Run([]string{`systemctl`, `enable`, `--now`, `firewalld`}, "", nil)
+	bash.Run([]string{`firewall-cmd`, `--reload`}, "", nil)
+}
+
+func (fw *firewalldFirewall) disable() {
+	bash.Run([]string{`systemctl`, `disable`, `--now`, `firewalld`}, "", nil)
+}
+
+func (fw *firewalldFirewall) status() (string, []string) {
+	out, err := fw.run(`--get-default-zone`)
+	if err != nil {
+		return "", nil
+	}
+
+	zone := strings.TrimSpace(string(out))
+	incoming := zone
+	switch zone {
+	case "drop":
+		incoming = "drop"
+	case "public":
+		incoming = "deny"
+	}
+
+	ports := []string{}
+	if out, err := fw.run(`--permanent`, `--zone=`+zone, `--list-ports`); err == nil {
+		ports = append(ports, strings.Fields(string(out))...)
+	}
+
+	if out, err := fw.run(`--permanent`, `--zone=`+zone, `--list-rich-rules`); err == nil {
+		for _, m := range regex.Comp(`port port="([^"]+)" protocol="(\w+)"`).RE.FindAllStringSubmatch(string(out), -1) {
+			if port := m[1] + "/" + m[2]; !goutil.Contains(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+	}
+
+	return incoming, ports
+}
+
+//* nftables
+
+const nftablesRuleset = "/etc/nftables/special-modifications.nft"
+
+type nftablesFirewall struct{}
+
+func (fw *nftablesFirewall) install() {
+	installPKG("nftables")
+}
+
+func (fw *nftablesFirewall) reset() {
+	os.Remove(nftablesRuleset)
+	bash.RunRaw(`nft list table inet special_modifications >/dev/null 2>&1 && nft delete table inet special_modifications`, "", nil)
+}
+
+func (fw *nftablesFirewall) apply(policy *firewallPolicy) error {
+	rules := []string{
+		`ct state established,related accept`,
+		`ct state invalid drop`,
+		`iif lo accept`,
+		`meta l4proto ipv6-icmp accept`,
+	}
+
+	if policy.incoming != "drop" {
+		rules = append(rules, `meta l4proto icmp accept`)
+	}
+
+	for _, rule := range policy.rules {
+		if rule.limit {
+			rules = append(rules, rule.proto+` dport `+rule.port+` ct state new limit rate 6/minute accept comment "`+rule.name+`"`)
+		} else {
+			rules = append(rules, rule.proto+` dport `+rule.port+` accept comment "`+rule.name+`"`)
+		}
+	}
+
+	if policy.incoming != "drop" {
+		rules = append(rules, `reject with icmpx type admin-prohibited`)
+	}
+
+	ruleset := `#!/usr/sbin/nft -f
+# generated by Special Modifications
+
+table inet special_modifications
+delete table inet special_modifications
+
+table inet special_modifications {
+	chain input {
+		type filter hook input priority filter; policy drop;
+		` + strings.Join(rules, "\n\t\t") + `
+	}
+
+	chain output {
+		type filter hook output priority filter; policy accept;
+	}
+}
+`
+
+	// check the new ruleset before it replaces the old one
+	os.MkdirAll("/etc/nftables", 0755)
+	tmp := nftablesRuleset + ".tmp"
+	if err := os.WriteFile(tmp, []byte(ruleset), 0644); err != nil {
+		return err
+	}
+
+	if out, err := bash.Run([]string{`nft`, `-c`, `-f`, tmp}, "", nil); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("invalid nftables ruleset: %s", strings.TrimSpace(string(out)))
+	}
+
+	if err := os.Rename(tmp, nftablesRuleset); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// include the ruleset from the config loaded by nftables.service
+	conf := nftablesConf()
+	include := nftablesInclude()
+	if buf, err := os.ReadFile(conf); err != nil || !strings.Contains(string(buf), include) {
+		if file, err := os.OpenFile(conf, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			file.WriteString("\n" + include + "\n")
+			file.Close()
+		}
+	}
+
+	if out, err := bash.Run([]string{`nft`, `-f`, nftablesRuleset}, "", nil); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (fw *nftablesFirewall) enable() {
+	disableFirewalls("nftables")
+	bash.Run([]string{`systemctl`, `enable`, `--now`, `nftables`}, "", nil)
+	bash.Run([]string{`nft`, `-f`, nftablesRuleset}, "", nil)
+}
+
+func (fw *nftablesFirewall) disable() {
+	bash.RunRaw(`nft list table inet special_modifications >/dev/null 2>&1 && nft delete table inet special_modifications`, "", nil)
+
+	// only stop nftables.service if it was loading the ruleset, stopping it flushes every table
+	conf := nftablesConf()
+	buf, err := os.ReadFile(conf)
+	if err != nil || !strings.Contains(string(buf), nftablesInclude()) {
+		return
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.TrimSpace(line) != nftablesInclude() {
+			lines = append(lines, line)
+		}
+	}
+	os.WriteFile(conf, []byte(strings.Join(lines, "\n")), 0644)
+
+	bash.Run([]string{`systemctl`, `disable`, `--now`, `nftables`}, "", nil)
+}
+
+// nftablesConf returns the config loaded by nftables.service
+func nftablesConf() string {
+	if PM == "dnf" {
+		return "/etc/sysconfig/nftables.conf"
+	}
+	return "/etc/nftables.conf"
+}
+
+func nftablesInclude() string {
+	return `include "` + nftablesRuleset + `"`
+}
+
+func (fw *nftablesFirewall) status() (string, []string) {
+	out, err := bash.Run([]string{`nft`, `list`, `chain`, `inet`, `special_modifications`, `input`}, "", nil)
+	if err != nil {
+		return "", nil
+	}
+
+	incoming := "drop"
+	if strings.Contains(string(out), "reject") {
+		incoming = "deny"
+	}
+
+	ports := []string{}
+	for _, m := range regex.Comp(`(tcp|udp) dport (\d+(?:-\d+)?)`).RE.FindAllStringSubmatch(string(out), -1) {
+		if port := m[2] + "/" + m[1]; !goutil.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+
+	return incoming, ports
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFirewallPolicyFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		profile    string
+		allow      string
+		disableSSH bool
+		incoming   string
+		ports      []string
+	}{
+		{
+			name:     "server profile",
+			profile:  "server",
+			incoming: "deny",
+			ports:    []string{"546/udp", "22/tcp", "80/tcp", "443/tcp"},
+		},
+		{
+			name:     "paranoid profile drops",
+			profile:  "paranoid",
+			incoming: "drop",
+			ports:    []string{},
+		},
+		{
+			name:     "unknown profile falls back to desktop",
+			profile:  "unknown",
+			incoming: "deny",
+			ports:    []string{"546/udp", "5353/udp", "1714-1764/tcp", "1714-1764/udp", "22000/tcp", "22000/udp", "21027/udp", "631/tcp", "631/udp"},
+		},
+		{
+			name:     "services and custom ports",
+			profile:  "paranoid",
+			allow:    "ssh 8080 51820/udp, 6000-6010/tcp",
+			incoming: "drop",
+			ports:    []string{"22/tcp", "8080/tcp", "51820/udp", "6000-6010/tcp"},
+		},
+		{
+			name:     "duplicate ports are added once",
+			profile:  "server",
+			allow:    "ssh 22/tcp 443",
+			incoming: "deny",
+			ports:    []string{"546/udp", "22/tcp", "80/tcp", "443/tcp"},
+		},
+		{
+			name:     "invalid ports are ignored",
+			profile:  "paranoid",
+			allow:    "0 70000 2000-1000 1-65536 8080/icmp abc 80-",
+			incoming: "drop",
+			ports:    []string{},
+		},
+		{
+			name:     "port range bounds",
+			profile:  "paranoid",
+			allow:    "1 65535 1000-1000/udp",
+			incoming: "drop",
+			ports:    []string{"1/tcp", "65535/tcp", "1000-1000/udp"},
+		},
+		{
+			name:       "disabled ssh is removed",
+			profile:    "server",
+			allow:      "ssh",
+			disableSSH: true,
+			incoming:   "deny",
+			ports:      []string{"546/udp", "80/tcp", "443/tcp"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := newConfig()
+			opts.setValue("firewallProfile", test.profile)
+			opts.setValue("firewallAllow", test.allow)
+			opts.setBool("disableSSH", test.disableSSH)
+
+			policy := firewallPolicyFor(opts)
+			if policy.incoming != test.incoming {
+				t.Errorf("incoming = %q, want %q", policy.incoming, test.incoming)
+			}
+
+			ports := []string{}
+			for _, rule := range policy.rules {
+				ports = append(ports, rule.port+"/"+rule.proto)
+			}
+			if !slices.Equal(ports, test.ports) {
+				t.Errorf("ports = %v, want %v", ports, test.ports)
+			}
+		})
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/regex"
+)
+
+// fedora longterm kernels are provided by a copr repo for each lts series,
+// this one is used when the newest series can not be looked up
+const kernelLongtermCopr = "kwizart/kernel-longterm-6.12"
+
+const grubDefaultPath = "/etc/default/grub"
+
+const dnfConfPath = "/etc/dnf/dnf.conf"
+
+// kernelKeepPath keeps the number of kernels to keep, for the dnf.conf asset
+const kernelKeepPath = stateDir + "/kernel-keep"
+
+// kernelVariants returns the kernel variants that are packaged for the distro
+func kernelVariants() []string {
+	switch {
+	case PM == "dnf":
+		return []string{"distro", "lts", "mainline"}
+	case osLike("ubuntu"):
+		// ubuntu does not package mainline kernels, hwe-edge is the newest it has
+		return []string{"distro", "lts", "hwe-edge"}
+	default:
+		return []string{"distro", "lts"}
+	}
+}
+
+func kernelConfig(opts *config) {
+	opts.addSelect("kernelVariant", "Which kernel would you like to use?", kernelVariants()...)
+
+	if PM == "dnf" && opts.value("kernelVariant") == "lts" {
+		copr := latestLongtermCopr()
+		opts.addValue("kernelLongtermCopr", "Which copr repo should the lts kernel come from (default: "+copr+")?", copr)
+	}
+
+	keep, err := strconv.Atoi(opts.addValue("kernelKeep", "How many kernels should be kept installed (default: 2)?", "2"))
+	if err != nil || keep < 1 {
+		keep = 2
+	}
+	if PM == "dnf" && keep < 2 {
+		fmt.Println("dnf does not allow keeping less than 2 kernels, keeping 2")
+		keep = 2
+	}
+	opts.setValue("kernelKeep", strconv.Itoa(keep))
+}
+
+// kernelKeep returns the number of kernels to keep, from the last kernel update
+func kernelKeep() int {
+	if buf, err := os.ReadFile(kernelKeepPath); err == nil {
+		if keep, err := strconv.Atoi(strings.TrimSpace(string(buf))); err == nil && keep >= 2 {
+			return keep
+		}
+	}
+	return 2
+}
+
+// installKernel upgrades the kernel and returns true if a reboot is needed to use it
+func installKernel(opts *config) bool {
+	running := runningKernel()
+
+	fmt.Println("Running Kernel:", running)
+	fmt.Println("Installed Kernels:")
+	for _, ver := range installedKernels() {
+		fmt.Println("  " + ver)
+	}
+
+	progressBar := bash.NewProgressBar("Updating Kernel")
+
+	progressBar.SetSize(3)
+
+	//* install kernel
+	progressBar.Msg("Installing Kernel")
+	switch PM {
+	case "dnf":
+		switch opts.value("kernelVariant") {
+		case "lts":
+			installPKG(`dnf-plugins-core`)
+			copr := opts.value("kernelLongtermCopr")
+			if copr == "" {
+				copr = latestLongtermCopr()
+			}
+			bash.Run([]string{`dnf`, `-y`, `copr`, `enable`, copr}, "", nil, true)
+			installPKG(`kernel-longterm`)
+		case "mainline":
+			installPKG(`dnf-plugins-core`)
+			bash.Run([]string{`dnf`, `-y`, `copr`, `enable`, `@kernel-vanilla/mainline`}, "", nil, true)
+		}
+
+		bash.Run([]string{`dnf`, `-y`, `upgrade`, `--refresh`, `kernel`, `kernel-core`, `kernel-modules`}, "", nil, true)
+	case "apt":
+		bash.Run([]string{`apt`, `-y`, `update`}, "", nil, true)
+
+		pkg := kernelMetaPackage(opts)
+		if osLike("ubuntu") || osRelease("ID") != "debian" || opts.value("kernelVariant") == "lts" || !installBackportsKernel(pkg) {
+			installPKG(pkg)
+		}
+	}
+	progressBar.Step()
+
+	//* remove old kernels
+	progressBar.Msg("Removing Old Kernels")
+	cleanKernels(opts)
+	progressBar.Step()
+
+	//* verify bootloader
+	progressBar.Msg("Verifying Bootloader")
+	kernels := installedKernels()
+	latest := variantKernel(opts)
+
+	bootOK := hasBootEntry(latest)
+	if !bootOK {
+		switch PM {
+		case "dnf":
+			bash.Run([]string{`kernel-install`, `add`, latest, `/lib/modules/` + latest + `/vmlinuz`}, "", nil, true)
+			bash.Run([]string{`grub2-mkconfig`, `-o`, `/boot/grub2/grub.cfg`}, "", nil, true)
+		case "apt":
+			bash.Run([]string{`update-initramfs`, `-u`, `-k`, latest}, "", nil, true)
+			bash.Run([]string{`update-grub`}, "", nil, true)
+		}
+		bootOK = hasBootEntry(latest)
+	}
+
+	// a newer distro kernel would boot before the lts kernel
+	if bootOK {
+		setDefaultKernel(latest, opts.value("kernelVariant") == "lts")
+	}
+	progressBar.Step()
+	progressBar.Stop()
+
+	fmt.Println("Installed Kernels:")
+	for _, ver := range kernels {
+		fmt.Println("  " + ver)
+	}
+
+	if !bootOK {
+		fmt.Println("Warning: No bootloader entry was found for kernel " + latest)
+	}
+
+	if latest != running {
+		fmt.Println("Reboot to start using kernel " + latest)
+		return true
+	}
+
+	fmt.Println("Kernel is up to date")
+	return false
+}
+
+func runningKernel() string {
+	if out, err := bash.Run([]string{`uname`, `-r`}, "", nil); err == nil {
+		return strings.TrimSpace(string(out))
+	}
+	return ""
+}
+
+// installedKernels returns the installed kernel versions, sorted from oldest to newest
+func installedKernels() []string {
+	var out []byte
+	var err error
+
+	switch PM {
+	case "dnf":
+		out, err = bash.RunRaw(`rpm -q --qf '%{VERSION}-%{RELEASE}.%{ARCH}\n' kernel-core kernel-longterm-core 2>/dev/null | grep -v ' ' | sort -V`, "", nil)
+	case "apt":
+		out, err = bash.RunRaw(`dpkg-query -W -f='${db:Status-Abbrev} ${Package}\n' 'linux-image-[0-9]*' 2>/dev/null | grep '^ii' | sed -r 's/^ii\s+linux-image-//' | sort -V`, "", nil)
+	}
+
+	if err != nil {
+		return []string{}
+	}
+
+	kernels := []string{}
+	for _, ver := range strings.Split(string(out), "\n") {
+		if ver = strings.TrimSpace(ver); ver != "" {
+			kernels = append(kernels, ver)
+		}
+	}
+	return kernels
+}
+
+// variantKernel returns the newest installed kernel of the chosen variant, falling back to the newest kernel
+func variantKernel(opts *config) string {
+	ver := ""
+	switch PM {
+	case "dnf":
+		pkg := "kernel-core"
+		if opts.value("kernelVariant") == "lts" {
+			pkg = "kernel-longterm-core"
+		}
+		if out, err := bash.RunRaw(`rpm -q --qf '%{VERSION}-%{RELEASE}.%{ARCH}\n' `+pkg+` 2>/dev/null | grep -v ' ' | sort -V | tail -n1`, "", nil); err == nil {
+			ver = strings.TrimSpace(string(out))
+		}
+	case "apt":
+		ver = aptMetaKernel(kernelMetaPackage(opts))
+	}
+
+	if ver == "" {
+		kernels := installedKernels()
+		if len(kernels) == 0 {
+			return runningKernel()
+		}
+		return kernels[len(kernels)-1]
+	}
+	return ver
+}
+
+// kernelMetaPackage returns the apt package that pulls in the kernel of the chosen variant
+func kernelMetaPackage(opts *config) string {
+	if osLike("ubuntu") {
+		switch opts.value("kernelVariant") {
+		case "lts":
+			return `linux-generic`
+		case "hwe-edge":
+			return ubuntuHWEKernel(true)
+		default:
+			return ubuntuHWEKernel(false)
+		}
+	}
+
+	arch := "amd64"
+	if out, err := bash.Run([]string{`dpkg`, `--print-architecture`}, "", nil); err == nil && len(out) != 0 {
+		arch = strings.TrimSpace(string(out))
+	}
+	return `linux-image-` + arch
+}
+
+// aptMetaKernel follows the dependencies of a kernel meta package to the kernel version it installed
+func aptMetaKernel(pkg string) string {
+	// linux-generic depends on linux-image-generic, which depends on the versioned kernel
+	for range 3 {
+		out, err := bash.Run([]string{`dpkg-query`, `-W`, `-f=${Depends}`, pkg}, "", nil)
+		if err != nil {
+			return ""
+		}
+
+		if m := regex.Comp(`linux-image-(?:unsigned-)?([0-9][^\s,(]*)`).RE.FindStringSubmatch(string(out)); m != nil {
+			return m[1]
+		}
+
+		m := regex.Comp(`linux-image-[a-z][^\s,(]*`).RE.FindString(string(out))
+		if m == "" {
+			return ""
+		}
+		pkg = m
+	}
+	return ""
+}
+
+// setDefaultKernel makes the bootloader start a kernel version by default
+//
+// on grub systems without grubby, the default is only pinned for the lts kernel,
+// and a previous pin is removed for other variants
+func setDefaultKernel(ver string, pin bool) {
+	if out, err := bash.Run([]string{`which`, `grubby`}, "", nil); err == nil && len(out) != 0 {
+		if _, err := bash.Run([]string{`grubby`, `--set-default`, `/boot/vmlinuz-` + ver}, "", nil); err != nil {
+			fmt.Println("Warning: Failed to make kernel " + ver + " the boot default")
+		}
+		return
+	}
+
+	buf, err := os.ReadFile(grubDefaultPath)
+	if err != nil {
+		return
+	}
+
+	def := `0`
+	if pin {
+		id := grubEntryID(ver)
+		if id == "" {
+			fmt.Println("Warning: No grub menu entry was found for kernel " + ver + ", it may not boot by default")
+			return
+		}
+		def = `"` + id + `"`
+	} else if !strings.Contains(string(buf), "# lts kernel set by Special Modifications") {
+		return
+	}
+
+	line := `GRUB_DEFAULT=` + def
+	if pin {
+		line += " # lts kernel set by Special Modifications"
+	}
+
+	if regex.Comp(`(?m)^GRUB_DEFAULT=.*$`).Match(buf) {
+		buf = regex.Comp(`(?m)^GRUB_DEFAULT=.*$`).RepLit(buf, []byte(line))
+	} else {
+		buf = append(buf, []byte("\n"+line+"\n")...)
+	}
+
+	os.WriteFile(grubDefaultPath, buf, 0644)
+	bash.Run([]string{`update-grub`}, "", nil, true)
+}
+
+// grubEntryID returns the grub menu path of a kernel version (example: "gnulinux-advanced-id>gnulinux-6.8.0-45-generic-advanced-id")
+func grubEntryID(ver string) string {
+	buf, err := os.ReadFile("/boot/grub/grub.cfg")
+	if err != nil {
+		return ""
+	}
+
+	entry := regex.Comp(`menuentry '[^']*' [^\n]*\$menuentry_id_option '(gnulinux-%1-advanced-[^']+)'`, ver).RE.FindSubmatch(buf)
+	if entry == nil {
+		return ""
+	}
+
+	// versioned entries are listed in the advanced options submenu
+	if sub := regex.Comp(`submenu '[^']*' [^\n]*\$menuentry_id_option '([^']+)'`).RE.FindSubmatch(buf); sub != nil {
+		return string(sub[1]) + ">" + string(entry[1])
+	}
+	return string(entry[1])
+}
+
+// latestLongtermCopr returns the copr repo of the newest longterm kernel series
+func latestLongtermCopr() string {
+	owner, _, _ := strings.Cut(kernelLongtermCopr, "/")
+
+	out, err := bash.Run([]string{`curl`, `-fsSL`, `https://copr.fedorainfracloud.org/api_3/project/list?ownername=` + owner}, "", nil)
+	if err != nil {
+		return kernelLongtermCopr
+	}
+
+	list := struct {
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+	}{}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return kernelLongtermCopr
+	}
+
+	series := []string{}
+	for _, item := range list.Items {
+		if m := regex.Comp(`^kernel-longterm-([0-9]+\.[0-9]+)$`).RE.FindStringSubmatch(item.Name); m != nil {
+			series = append(series, m[1])
+		}
+	}
+	if len(series) == 0 {
+		return kernelLongtermCopr
+	}
+
+	// compare the major and minor version as numbers, 6.12 is newer than 6.6
+	sort.Slice(series, func(i, j int) bool {
+		iMajor, iMinor, _ := strings.Cut(series[i], ".")
+		jMajor, jMinor, _ := strings.Cut(series[j], ".")
+		if iMajor != jMajor {
+			a, _ := strconv.Atoi(iMajor)
+			b, _ := strconv.Atoi(jMajor)
+			return a < b
+		}
+		a, _ := strconv.Atoi(iMinor)
+		b, _ := strconv.Atoi(jMinor)
+		return a < b
+	})
+	return owner + "/kernel-longterm-" + series[len(series)-1]
+}
+
+// ubuntuHWEKernel returns the newest hwe kernel package, derivatives do not share ubuntu's VERSION_ID
+func ubuntuHWEKernel(edge bool) string {
+	out, err := bash.Run([]string{`apt-cache`, `pkgnames`, `linux-generic-hwe-`}, "", nil)
+	if err != nil {
+		return `linux-generic`
+	}
+
+	pkgs := []string{}
+	for _, pkg := range strings.Fields(string(out)) {
+		if strings.HasSuffix(pkg, "-edge") == edge {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	if len(pkgs) == 0 {
+		if edge {
+			fmt.Println("No hwe-edge kernel is packaged for this release, using the hwe kernel...")
+			return ubuntuHWEKernel(false)
+		}
+		return `linux-generic`
+	}
+
+	sort.Strings(pkgs)
+	return pkgs[len(pkgs)-1]
+}
+
+// installBackportsKernel installs a kernel from debian backports, and returns false if backports are not available
+func installBackportsKernel(pkg string) bool {
+	codename := osRelease("VERSION_CODENAME")
+	if codename == "" {
+		return false
+	}
+
+	suite := codename + `-backports`
+	listPath := "/etc/apt/sources.list.d/backports.list"
+	added := false
+
+	// backports may already be enabled in another list
+	if !hasAptSuite(suite) {
+		if _, err := os.Stat(listPath); err == nil {
+			return false
+		}
+
+		os.WriteFile(listPath, []byte(`deb http://deb.debian.org/debian `+suite+` main contrib non-free-firmware`+"\n"), 0644)
+		bash.Run([]string{`apt`, `-y`, `update`}, "", nil, true)
+		added = true
+
+		// testing, sid and unknown releases have no backports suite
+		if !hasAptSuite(suite) {
+			fmt.Println("No backports suite found for " + codename + ", using the distro kernel...")
+			removeBackportsList(listPath)
+			return false
+		}
+	}
+
+	if _, err := bash.Run([]string{`apt`, `-y`, `-t`, suite, `install`, pkg}, "", []string{`DEBIAN_FRONTEND=noninteractive`}, true); err != nil {
+		fmt.Println("Failed to install the backports kernel, using the distro kernel...")
+		if added {
+			removeBackportsList(listPath)
+		}
+		return false
+	}
+
+	return true
+}
+
+// hasAptSuite returns true if apt has a release file for the suite
+func hasAptSuite(suite string) bool {
+	out, _ := bash.Run([]string{`apt-cache`, `policy`}, "", nil)
+	return strings.Contains(string(out), "n="+suite+",")
+}
+
+func removeBackportsList(path string) {
+	os.Remove(path)
+	bash.Run([]string{`apt`, `-y`, `update`}, "", nil, true)
+}
+
+// cleanKernels removes old kernels, keeping the newest, the running, and the lts kernel
+func cleanKernels(opts *config) {
+	limit, err := strconv.Atoi(opts.value("kernelKeep"))
+	if err != nil || limit < 1 {
+		limit = 2
+	}
+
+	switch PM {
+	case "dnf":
+		if limit < 2 {
+			fmt.Println("dnf does not allow keeping less than 2 kernels, keeping 2")
+			limit = 2
+		}
+
+		// the dnf.conf asset reads the limit from kernelKeepPath, so installing the files again keeps it
+		os.MkdirAll(stateDir, 0700)
+		os.WriteFile(kernelKeepPath, []byte(strconv.Itoa(limit)+"\n"), 0644)
+		if file, err := os.OpenFile(dnfConfPath, os.O_RDWR, 0644); err == nil {
+			regex.Comp(`(?m)^installonly_limit=.*$`).RepFile(file, []byte(`installonly_limit=`+strconv.Itoa(limit)), false)
+			file.Sync()
+			file.Close()
+		}
+
+		// the limit is counted for each package, so the newest kernel-longterm is always kept
+		bash.Run([]string{`dnf`, `-y`, `remove`, `--oldinstallonly`, `--setopt`, `installonly_limit=` + strconv.Itoa(limit), `kernel`}, "", nil, true)
+		if opts.value("kernelVariant") == "lts" {
+			bash.Run([]string{`dnf`, `-y`, `remove`, `--oldinstallonly`, `--setopt`, `installonly_limit=` + strconv.Itoa(limit), `kernel-longterm`}, "", nil, true)
+		}
+	case "apt":
+		running := runningKernel()
+		kernels := installedKernels()
+
+		// the lts kernel is older than a hwe or backports kernel that may still be installed
+		lts := ""
+		if opts.value("kernelVariant") == "lts" {
+			lts = variantKernel(opts)
+		}
+
+		for i, ver := range kernels {
+			if i >= len(kernels)-limit || ver == running || ver == lts {
+				continue
+			}
+			bash.Run([]string{`apt`, `-y`, `purge`, `linux-image-` + ver}, "", []string{`DEBIAN_FRONTEND=noninteractive`}, true)
+		}
+
+		bash.Run([]string{`apt`, `-y`, `autoremove`, `--purge`}, "", []string{`DEBIAN_FRONTEND=noninteractive`}, true)
+	}
+}
+
+// hasBootEntry checks that the bootloader was regenerated for a kernel version
+func hasBootEntry(ver string) bool {
+	if ver == "" {
+		return false
+	}
+
+	if entries, err := filepath.Glob("/boot/loader/entries/*" + ver + ".conf"); err == nil && len(entries) != 0 {
+		return true
+	}
+
+	for _, path := range []string{"/boot/grub2/grub.cfg", "/boot/grub/grub.cfg"} {
+		if buf, err := os.ReadFile(path); err == nil && strings.Contains(string(buf), "vmlinuz-"+ver) {
+			return true
+		}
+	}
+
+	return false
+}
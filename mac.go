@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+const selinuxConfigPath = "/etc/selinux/config"
+
+// paths written by the installer, that need their selinux labels restored
+var selinuxRelabelPaths = []string{"/etc/ssh", "/etc/systemd", "/etc/fail2ban", "/etc/security", "/etc/stubby", "/etc/dnf", "/etc/clamd.d", "/etc/rkhunter.conf.local", "/etc/profile.d", "/etc/resolv.conf"}
+
+func macConfig(opts *config) {
+	// permissive selinux logs what enforcing would deny, so do not default to enforcing when something would break
+	def := true
+	if framework, mode := macStatus(); framework == "selinux" && mode == "permissive" {
+		if denials := macDenials("recent"); len(denials) != 0 {
+			fmt.Println("SELinux would deny (run `mac --since=recent` for details):")
+			for _, denial := range denials {
+				fmt.Println("  " + denial)
+			}
+			def = false
+		}
+	}
+
+	opts.addBool("macEnforce", "Would you like to set SELinux/AppArmor to enforcing mode?", def)
+}
+
+// macStatus returns the mac framework in use and its mode
+func macStatus() (string, string) {
+	if out, err := bash.Run([]string{`getenforce`}, "", nil); err == nil {
+		return "selinux", strings.ToLower(strings.TrimSpace(string(out)))
+	}
+
+	if _, err := bash.Run([]string{`aa-enabled`, `--quiet`}, "", nil); err == nil {
+		if profiles := apparmorProfiles(); len(profiles) != 0 {
+			complain := 0
+			for _, mode := range profiles {
+				if mode == "complain" {
+					complain++
+				}
+			}
+			if complain != 0 {
+				return "apparmor", "enabled (" + strconv.Itoa(complain) + " profiles in complain mode)"
+			}
+		}
+		return "apparmor", "enabled"
+	} else if _, err := os.Stat("/sys/kernel/security/apparmor"); err == nil {
+		return "apparmor", "disabled"
+	}
+
+	return "", "none"
+}
+
+// apparmorProfiles returns the loaded apparmor profiles and their modes
+func apparmorProfiles() map[string]string {
+	profiles := map[string]string{}
+
+	out, err := bash.Run([]string{`aa-status`, `--json`}, "", nil)
+	if err != nil {
+		return profiles
+	}
+
+	if json, err := goutil.JSON.Parse(out); err == nil {
+		if list, ok := json["profiles"].(map[string]interface{}); ok {
+			for name, mode := range list {
+				if m, ok := mode.(string); ok {
+					profiles[name] = m
+				}
+			}
+		}
+	}
+
+	return profiles
+}
+
+// configureMAC sets up selinux or apparmor, and returns true if a reboot is needed to finish
+func configureMAC(opts *config) bool {
+	if PM == "dnf" {
+		installPKG(`selinux-policy-devel`, `policycoreutils`, `audit`)
+	} else if PM == "apt" {
+		installPKG(`apparmor`, `apparmor-utils`, `apparmor-profiles`, `auditd`)
+	}
+
+	reboot := false
+
+	framework, mode := macStatus()
+	switch framework {
+	case "selinux":
+		fmt.Println("SELinux: " + mode)
+		reboot = configureSELinux(opts, mode)
+	case "apparmor":
+		fmt.Println("AppArmor: " + mode)
+		configureAppArmor(opts, mode)
+	default:
+		fmt.Println("No SELinux or AppArmor support found, skipping")
+		return false
+	}
+
+	if denials := macDenials("today"); len(denials) != 0 {
+		fmt.Println("Recent " + framework + " denials (run `mac` for details):")
+		for _, denial := range denials {
+			fmt.Println("  " + denial)
+		}
+	}
+
+	return reboot
+}
+
+// configureSELinux returns true if a reboot is needed to relabel the filesystem
+func configureSELinux(opts *config, mode string) bool {
+	if mode != "disabled" {
+		// files written by the installer may have been created with the wrong labels
+		paths := []string{quarantinePath()}
+		for _, path := range selinuxRelabelPaths {
+			if _, err := os.Stat(path); err == nil {
+				paths = append(paths, path)
+			}
+		}
+		bash.Run(append([]string{`restorecon`, `-R`}, paths...), "", nil)
+	}
+
+	if !opts.bool("macEnforce") {
+		return false
+	}
+
+	switch mode {
+	case "permissive":
+		// the answer was given before the install, which can cause new denials
+		if denials := macDenials("recent"); len(denials) != 0 && AssumeYes {
+			fmt.Println("SELinux: keeping permissive mode, found recent denials (run `mac --since=recent` for details)")
+			return false
+		}
+
+		setSELinuxMode("enforcing")
+		bash.Run([]string{`setenforce`, `1`}, "", nil)
+		fmt.Println("SELinux: set to enforcing")
+	case "disabled":
+		// the whole filesystem needs a relabel, and enforcing an unlabeled system can leave it unbootable
+		setSELinuxMode("permissive")
+		os.WriteFile("/.autorelabel", []byte{}, 0644)
+		fmt.Println("SELinux: set to permissive, the filesystem will be relabeled on the next reboot")
+		fmt.Println("SELinux: run this step again after rebooting to switch to enforcing")
+		return true
+	}
+
+	return false
+}
+
+func setSELinuxMode(mode string) {
+	if buf, err := os.ReadFile(selinuxConfigPath); err == nil {
+		buf = regex.Comp(`(?m)^SELINUX=.*$`).Rep(buf, []byte("SELINUX="+mode))
+		os.WriteFile(selinuxConfigPath, buf, 0644)
+	}
+}
+
+func configureAppArmor(opts *config, mode string) {
+	if mode == "disabled" {
+		fmt.Println("AppArmor: disabled in the kernel, add `apparmor=1 security=apparmor` to the kernel command line to enable it")
+		return
+	}
+
+	bash.Run([]string{`systemctl`, `enable`, `--now`, `apparmor`}, "", nil)
+
+	if !opts.bool("macEnforce") {
+		return
+	}
+
+	// only enforce profiles for programs that are installed, other profiles may be left in complain mode on purpose
+	profiles := apparmorProfiles()
+	names := []string{}
+	for name, mode := range profiles {
+		if mode == "complain" && filepath.IsAbs(name) {
+			if _, err := os.Stat(name); err == nil {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := bash.Run([]string{`aa-enforce`, name}, "", nil); err == nil {
+			fmt.Println("AppArmor: enforcing " + name)
+		}
+	}
+}
+
+// macDenials returns a summary of the selinux and apparmor denials since a time accepted by ausearch
+//
+// (example: "recent", "today", "this-week")
+func macDenials(since string) []string {
+	lines := []string{}
+
+	if out, err := bash.Run([]string{`ausearch`, `-m`, `AVC,USER_AVC`, `-ts`, since, `--raw`}, "", nil); err == nil {
+		lines = strings.Split(string(out), "\n")
+	} else {
+		journalSince := map[string]string{"recent": "-10min", "today": "today", "yesterday": "yesterday", "this-week": "-7days"}[since]
+		if journalSince == "" {
+			journalSince = "today"
+		}
+
+		out, _ := bash.Run([]string{`journalctl`, `-k`, `-o`, `cat`, `--no-pager`, `--since=` + journalSince}, "", nil)
+		lines = strings.Split(string(out), "\n")
+	}
+
+	reField := regex.Comp(`(\w+)=("[^"]*"|\S+)`)
+	rePerms := regex.Comp(`denied\s+\{ ([^}]+) \}`)
+
+	counts := map[string]int{}
+	for _, line := range lines {
+		if !strings.Contains(line, "avc:  denied") && !strings.Contains(line, `apparmor="DENIED"`) {
+			continue
+		}
+
+		fields := map[string]string{}
+		for _, m := range reField.RE.FindAllStringSubmatch(line, -1) {
+			if _, ok := fields[m[1]]; !ok {
+				fields[m[1]] = strings.Trim(m[2], `"`)
+			}
+		}
+
+		var summary string
+		if m := rePerms.RE.FindStringSubmatch(line); m != nil {
+			summary = fields["comm"] + ": denied " + strings.TrimSpace(m[1]) + " on " + fields["name"] + " (" + fields["tclass"] + ", " + fields["tcontext"] + ")"
+		} else {
+			summary = fields["profile"] + ": denied " + fields["operation"] + " " + fields["requested_mask"] + " on " + fields["name"]
+		}
+		counts[summary]++
+	}
+
+	denials := []string{}
+	for summary, count := range counts {
+		if count > 1 {
+			summary += " (x" + strconv.Itoa(count) + ")"
+		}
+		denials = append(denials, summary)
+	}
+	sort.Strings(denials)
+
+	return denials
+}
+
+// macCLI handles the `mac [--since=today]` command
+func macCLI() {
+	framework, mode := macStatus()
+	if framework == "" {
+		fmt.Println("No SELinux or AppArmor support found")
+		return
+	}
+	fmt.Println(framework + ": " + mode)
+
+	since := cliArgs["since"]
+	if since == "" {
+		since = "today"
+	}
+
+	denials := macDenials(since)
+	if len(denials) == 0 {
+		fmt.Println("No denials since " + since)
+		return
+	}
+
+	fmt.Println("Denials since " + since + ":")
+	for _, denial := range denials {
+		fmt.Println("  " + denial)
+	}
+
+	if framework == "selinux" {
+		fmt.Println("\nRun `ausearch -m AVC -ts " + since + " | audit2why` to see why they were denied")
+	} else {
+		fmt.Println("\nRun `aa-logprof` to update the profiles, or `aa-complain <profile>` to stop enforcing one")
+	}
+}
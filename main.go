@@ -53,11 +53,59 @@ func main() {
 		return
 	}
 
+	if cliArgs["0"] == "scan-schedule" {
+		scanScheduleCLI()
+		return
+	}
+
+	if cliArgs["0"] == "scan" {
+		scanCLI()
+		return
+	}
+
+	if cliArgs["0"] == "status" || cliArgs["0"] == "audit" {
+		auditCLI()
+		return
+	}
+
+	if cliArgs["0"] == "check" {
+		checkCLI()
+		return
+	}
+
+	if cliArgs["0"] == "mac" {
+		macCLI()
+		return
+	}
+
+	if cliArgs["0"] == "boot" {
+		bootCLI()
+		return
+	}
+
+	if cliArgs["0"] == "firewall-confirm" {
+		keepFirewall()
+		fmt.Println("Firewall changes kept")
+		return
+	}
+
+	if cliArgs["0"] == "firewall-rollback" {
+		rollbackFirewall()
+		return
+	}
+
+	if cliArgs["0"] == "quarantine" {
+		quarantineCLI()
+		return
+	}
+
 	if cliArgs["core"] == "true" || cliArgs["c"] == "true" {
 		fmt.Println("")
 		opts := newConfig()
 		installConfig(opts)
-		installCore(opts)
+		if installCore(opts) {
+			fmt.Println("A reboot is needed to finish installing")
+		}
 		return
 	} else if cliArgs["apps"] == "true" || cliArgs["a"] == "true" {
 		fmt.Println("")
@@ -69,17 +117,17 @@ func main() {
 		return
 	} else if cliArgs["update-kernel"] == "true" || cliArgs["kernel"] == "true" || cliArgs["k"] == "true" {
 		fmt.Println("")
-		fmt.Println("Not yet implemented")
+		opts := newConfig()
+		kernelConfig(opts)
+		installKernel(opts)
 		return
 	} else if cliArgs["all"] == "true" || cliArgs["install"] == "true" || cliArgs["i"] == "true" {
-		//todo: automatically run all install methods and kernel updates
-		// may also include system reboot
-		// also remember to include getting all config options before running anything
-
-		opts := newConfig()
-		installConfig(opts)
-
-		installCore(opts)
+		fmt.Println("")
+		runAll()
+		return
+	} else if cliArgs["resume"] == "true" {
+		fmt.Println("")
+		resumeRun()
 		return
 	}
 
@@ -93,7 +141,9 @@ func initPrompt() {
 	case 1:
 		opts := newConfig()
 		installConfig(opts)
-		installCore(opts)
+		if installCore(opts) {
+			fmt.Println("A reboot is needed to finish installing")
+		}
 		initPrompt()
 	case 2:
 		//todo: install apps
@@ -104,18 +154,12 @@ func initPrompt() {
 		fmt.Println("Not yet implemented!")
 		initPrompt()
 	case 4:
-		//todo: update linux kernel
-		fmt.Println("Not yet implemented!")
+		opts := newConfig()
+		kernelConfig(opts)
+		installKernel(opts)
 		initPrompt()
 	case 5:
-		//todo: automatically run all install methods and kernel updates
-		// may also include system reboot
-		// also remember to include getting all config options before running anything
-
-		opts := newConfig()
-		installConfig(opts)
-
-		installCore(opts)
+		runAll()
 	default:
 		fmt.Println("Exiting...")
 	}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tkdeng/regex"
+)
+
+const pwqualityPath = "/etc/security/pwquality.conf"
+
+// the policy is written to its own drop-in, so switching presets does not leave keys from the last one behind
+const pwqualityDropInPath = "/etc/security/pwquality.conf.d/50-special-modifications.conf"
+
+// passwordPolicies are the pwquality presets (a value of "" sets a flag without a value)
+//
+// each preset is at least as strict as the one before it on every key
+var passwordPolicies = map[string][][2]string{
+	"relaxed": {
+		{"minlen", "8"},
+		{"minclass", "0"},
+		{"dcredit", "0"},
+		{"ucredit", "0"},
+		{"lcredit", "0"},
+		{"ocredit", "0"},
+		{"maxrepeat", "0"},
+		{"maxsequence", "0"},
+		{"difok", "1"},
+		{"dictcheck", "1"},
+		{"usercheck", "1"},
+		{"enforcing", "1"},
+		{"retry", "3"},
+	},
+	"standard": {
+		{"minlen", "12"},
+		{"minclass", "2"},
+		{"dcredit", "0"},
+		{"ucredit", "0"},
+		{"lcredit", "0"},
+		{"ocredit", "0"},
+		{"maxrepeat", "3"},
+		{"maxsequence", "0"},
+		{"difok", "5"},
+		{"dictcheck", "1"},
+		{"usercheck", "1"},
+		{"enforcing", "1"},
+		{"retry", "3"},
+	},
+	"strict": {
+		{"minlen", "14"},
+		{"minclass", "4"},
+		{"dcredit", "-1"},
+		{"ucredit", "-1"},
+		{"lcredit", "-1"},
+		{"ocredit", "-1"},
+		{"maxrepeat", "3"},
+		{"maxsequence", "3"},
+		{"difok", "8"},
+		{"dictcheck", "1"},
+		{"usercheck", "1"},
+		{"enforcing", "1"},
+		{"retry", "3"},
+		{"enforce_for_root", ""},
+	},
+}
+
+func passwordConfig(opts *config) {
+	policy := opts.addSelect("passwordPolicy", "Which password policy would you like to use?", "standard", "relaxed", "strict", "custom")
+
+	if policy == "custom" {
+		opts.addValue("passwordMinlen", "Minimum password length (default: 12)?", "12")
+		opts.addValue("passwordMinclass", "Minimum number of character classes (default: 2)?", "2")
+		opts.addBool("passwordDictcheck", "Would you like to reject dictionary words in passwords?", true)
+	}
+
+	fmt.Println("Using " + policy + " password policy...")
+}
+
+func passwordPolicyFor(opts *config) [][2]string {
+	if policy, ok := passwordPolicies[opts.value("passwordPolicy")]; ok {
+		return policy
+	}
+
+	policy := [][2]string{}
+	for _, opt := range passwordPolicies["standard"] {
+		switch opt[0] {
+		case "minlen":
+			if n, err := strconv.Atoi(opts.value("passwordMinlen")); err == nil && n > 0 {
+				opt[1] = strconv.Itoa(n)
+			}
+		case "minclass":
+			if n, err := strconv.Atoi(opts.value("passwordMinclass")); err == nil && n >= 0 && n <= 4 {
+				opt[1] = strconv.Itoa(n)
+			}
+		case "dictcheck":
+			if !opts.bool("passwordDictcheck") {
+				opt[1] = "0"
+			}
+		}
+		policy = append(policy, opt)
+	}
+	return policy
+}
+
+type pwqualityConfig struct {
+	path  string
+	lines []string
+}
+
+func readPwqualityConfig(path string) *pwqualityConfig {
+	conf := &pwqualityConfig{path: path, lines: []string{}}
+	if buf, err := os.ReadFile(path); err == nil {
+		conf.lines = strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	}
+	return conf
+}
+
+// comment comments out the active settings of a key
+func (conf *pwqualityConfig) comment(key string) bool {
+	reKey := regex.Comp(`^\s*%1\s*(=.*|)$`, key)
+
+	changed := false
+	for i, l := range conf.lines {
+		if reKey.Match([]byte(l)) {
+			conf.lines[i] = "# " + l
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (conf *pwqualityConfig) save() error {
+	return os.WriteFile(conf.path, []byte(strings.Join(conf.lines, "\n")+"\n"), 0644)
+}
+
+// pwqualityConf returns the drop-in for the selected policy
+func pwqualityConf(opts *config) string {
+	lines := []string{"# generated by Special Modifications"}
+	for _, opt := range passwordPolicyFor(opts) {
+		if opt[1] == "" {
+			lines = append(lines, opt[0])
+		} else {
+			lines = append(lines, opt[0]+" = "+opt[1])
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func setPasswordPolicy(opts *config) {
+	if PM == "apt" {
+		installPKG(`libpam-pwquality`)
+	}
+
+	os.MkdirAll(filepath.Dir(pwqualityDropInPath), 0755)
+	if err := os.WriteFile(pwqualityDropInPath, []byte(pwqualityConf(opts)), 0644); err != nil {
+		fmt.Println("Failed to write password policy:", err)
+		return
+	}
+
+	// the drop-in can not unset flags like enforce_for_root, so the keys it sets are removed from the main config
+	// (the strict preset sets every key)
+	conf := readPwqualityConfig(pwqualityPath)
+	changed := false
+	for _, opt := range passwordPolicies["strict"] {
+		if conf.comment(opt[0]) {
+			changed = true
+		}
+	}
+	if changed {
+		conf.save()
+	}
+}
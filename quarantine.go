@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+const defaultQuarantineDir = "/VirusScan/quarantine"
+
+type quarantineEntry struct {
+	File      string    `json:"file"` // path in the quarantine directory
+	Original  string    `json:"original"`
+	Detection string    `json:"detection"`
+	Date      time.Time `json:"date"`
+}
+
+type quarantineIndex struct {
+	Dir     string             `json:"dir"`
+	Entries []*quarantineEntry `json:"entries"`
+}
+
+func loadQuarantine() *quarantineIndex {
+	index := &quarantineIndex{Dir: defaultQuarantineDir, Entries: []*quarantineEntry{}}
+	if buf, err := os.ReadFile(stateDir + "/quarantine.json"); err == nil {
+		json.Unmarshal(buf, index)
+	}
+	return index
+}
+
+func (index *quarantineIndex) save() {
+	if buf, err := json.MarshalIndent(index, "", "  "); err == nil {
+		os.MkdirAll(stateDir, 0700)
+		os.WriteFile(stateDir+"/quarantine.json", buf, 0600)
+	}
+}
+
+// quarantinePath returns the configured quarantine directory
+func quarantinePath() string {
+	return loadQuarantine().Dir
+}
+
+// setQuarantinePath creates the quarantine directory, and moves existing quarantined files into it
+func setQuarantinePath(dir string) error {
+	if dir == "" {
+		dir = defaultQuarantineDir
+	}
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("quarantine directory must be an absolute path: %s", dir)
+	}
+
+	index := loadQuarantine()
+	index.sync()
+
+	// only root should be able to access infected files
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	os.Chmod(dir, 0700)
+
+	if filepath.Clean(dir) != filepath.Clean(index.Dir) {
+		for _, entry := range index.Entries {
+			dest := filepath.Join(dir, filepath.Base(entry.File))
+			if err := os.Rename(entry.File, dest); err == nil {
+				entry.File = dest
+			}
+		}
+		index.Dir = dir
+	}
+
+	index.save()
+	return nil
+}
+
+// sync adds files quarantined by clamscan to the index, using the detections from the scan logs
+func (index *quarantineIndex) sync() {
+	logs := []string{}
+	for _, schedule := range loadScanSchedules() {
+		logs = append(logs, schedule.Log)
+	}
+	if files, err := filepath.Glob("/var/log/clamav/*.log"); err == nil {
+		for _, file := range files {
+			if !goutil.Contains(logs, file) {
+				logs = append(logs, file)
+			}
+		}
+	}
+
+	known := map[string]bool{}
+	for _, entry := range index.Entries {
+		known[entry.File] = true
+	}
+
+	reFound := regex.Comp(`^(.+): (.+) FOUND$`)
+	reMoved := regex.Comp(`^(.+): moved to '(.+)'$`)
+
+	for _, log := range logs {
+		buf, err := os.ReadFile(log)
+		if err != nil {
+			continue
+		}
+
+		detections := map[string]string{}
+		for _, line := range strings.Split(string(buf), "\n") {
+			if m := reFound.RE.FindStringSubmatch(line); m != nil {
+				detections[m[1]] = m[2]
+			} else if m := reMoved.RE.FindStringSubmatch(line); m != nil && !known[m[2]] {
+				if stat, err := os.Stat(m[2]); err == nil {
+					// moving a file keeps its modified time, but updates its change time
+					date := stat.ModTime()
+					if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+						date = time.Unix(sys.Ctim.Sec, sys.Ctim.Nsec)
+					}
+
+					index.Entries = append(index.Entries, &quarantineEntry{
+						File:      m[2],
+						Original:  m[1],
+						Detection: detections[m[1]],
+						Date:      date,
+					})
+					known[m[2]] = true
+				}
+			}
+		}
+	}
+
+	// drop entries that were removed outside of this tool
+	entries := []*quarantineEntry{}
+	for _, entry := range index.Entries {
+		if _, err := os.Stat(entry.File); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	index.Entries = entries
+}
+
+// find returns an entry by its list number or quarantined file name
+func (index *quarantineIndex) find(id string) *quarantineEntry {
+	if i, err := strconv.Atoi(id); err == nil && i >= 1 && i <= len(index.Entries) {
+		return index.Entries[i-1]
+	}
+
+	for _, entry := range index.Entries {
+		if filepath.Base(entry.File) == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (index *quarantineIndex) remove(entry *quarantineEntry) {
+	entries := []*quarantineEntry{}
+	for _, e := range index.Entries {
+		if e != entry {
+			entries = append(entries, e)
+		}
+	}
+	index.Entries = entries
+}
+
+// quarantineCLI handles the `quarantine [list|restore|delete|prune|location]` command
+func quarantineCLI() {
+	index := loadQuarantine()
+	index.sync()
+	defer func() {
+		index.save()
+	}()
+
+	switch cliArgs["1"] {
+	case "restore":
+		entry := index.find(cliArgs["2"])
+		if entry == nil {
+			fmt.Println("No quarantined file found:", cliArgs["2"])
+			return
+		}
+
+		if _, err := os.Stat(entry.Original); err == nil {
+			fmt.Println("A file already exists at:", entry.Original)
+			return
+		}
+
+		os.MkdirAll(filepath.Dir(entry.Original), 0755)
+		if err := os.Rename(entry.File, entry.Original); err != nil {
+			fmt.Println("Failed to restore file:", err)
+			return
+		}
+
+		index.remove(entry)
+		fmt.Println("Restored:", entry.Original)
+		fmt.Println("Warning: this file was detected as", entry.Detection)
+	case "delete", "rm":
+		entry := index.find(cliArgs["2"])
+		if entry == nil {
+			fmt.Println("No quarantined file found:", cliArgs["2"])
+			return
+		}
+
+		if err := os.Remove(entry.File); err != nil {
+			fmt.Println("Failed to delete file:", err)
+			return
+		}
+
+		index.remove(entry)
+		fmt.Println("Deleted:", entry.File)
+	case "prune":
+		days, err := strconv.Atoi(cliArgs["days"])
+		if err != nil || days < 0 {
+			days = 30
+		}
+
+		before := time.Now().AddDate(0, 0, -days)
+		for _, entry := range append([]*quarantineEntry{}, index.Entries...) {
+			if entry.Date.Before(before) && os.Remove(entry.File) == nil {
+				index.remove(entry)
+				fmt.Println("Deleted:", entry.File)
+			}
+		}
+	case "location":
+		if cliArgs["2"] == "" {
+			fmt.Println(index.Dir)
+			return
+		}
+
+		if err := setQuarantinePath(cliArgs["2"]); err != nil {
+			fmt.Println("Failed to set quarantine directory:", err)
+			return
+		}
+		index = loadQuarantine()
+
+		// scheduled scans need to move files into the new directory
+		for _, schedule := range loadScanSchedules() {
+			schedule.install()
+		}
+		fmt.Println("Quarantine directory:", index.Dir)
+	default:
+		if len(index.Entries) == 0 {
+			fmt.Println("No quarantined files in", index.Dir)
+			return
+		}
+
+		for i, entry := range index.Entries {
+			fmt.Printf("[%d] %s\n", i+1, filepath.Base(entry.File))
+			fmt.Println("    Detection: " + entry.Detection)
+			fmt.Println("    Original:  " + entry.Original)
+			fmt.Println("    Date:      " + entry.Date.Format("2006-01-02 15:04"))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+const resumeUnit = "special-modifications-resume.service"
+
+// give up on resuming if the system keeps failing to finish the remaining steps
+const resumeMaxAttempts = 3
+
+// scheduleResume saves the remaining steps and installs a service to run them on the next boot
+func scheduleResume(opts *config, steps []string) {
+	saveResume(opts, steps, 0)
+
+	exe, err := selfPath()
+	if err != nil {
+		fmt.Println("Failed to find executable, run with --resume after rebooting to finish the remaining steps")
+		return
+	}
+
+	// exec instead of oneshot, so the boot transaction, login and `systemd-analyze time` do not wait for the remaining steps
+	unit := `[Unit]
+Description=Special Modifications post-reboot continuation
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=exec
+WorkingDirectory=` + filepath.Dir(exe) + `
+ExecStart=` + exe + ` --resume --assume-yes
+StandardOutput=journal
+StandardError=journal
+SyslogIdentifier=special-modifications
+RuntimeMaxSec=6h
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	os.WriteFile("/etc/systemd/system/"+resumeUnit, []byte(unit), 0644)
+	bash.Run([]string{`systemctl`, `daemon-reload`}, "", nil)
+	bash.Run([]string{`systemctl`, `enable`, resumeUnit}, "", nil)
+}
+
+// selfPath returns the path of this executable, for units that run it later
+func selfPath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if path, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = path
+	}
+	return exe, nil
+}
+
+// resumeRun runs the steps that were left for after a reboot
+func resumeRun() {
+	buf, err := os.ReadFile(stateDir + "/resume.json")
+	if err != nil {
+		fmt.Println("Nothing to resume")
+		removeResume()
+		return
+	}
+
+	json, err := goutil.JSON.Parse(buf)
+	if err != nil {
+		fmt.Println("Failed to read resume state:", err)
+		removeResume()
+		return
+	}
+
+	opts := newConfig()
+	if values, ok := json["config"].(map[string]interface{}); ok {
+		for key, val := range values {
+			if v, ok := val.(string); ok {
+				opts.setValue(key, v)
+			}
+		}
+	}
+
+	steps := []string{}
+	if list, ok := json["steps"].([]interface{}); ok {
+		for _, val := range list {
+			if v, ok := val.(string); ok {
+				steps = append(steps, v)
+			}
+		}
+	}
+
+	attempts := 0
+	if val, ok := json["attempts"].(float64); ok {
+		attempts = int(val)
+	}
+	attempts++
+
+	if attempts > resumeMaxAttempts {
+		fmt.Println("Giving up on remaining steps after "+strconv.Itoa(resumeMaxAttempts)+" attempts:", steps)
+		removeResume()
+		return
+	}
+
+	// save the attempt first, in case a step crashes or reboots the system
+	saveResume(opts, steps, attempts)
+
+	fmt.Println("Resuming steps (attempt "+strconv.Itoa(attempts)+"):", steps)
+	runSteps(opts, steps)
+
+	fmt.Println("Finished remaining steps")
+	removeResume()
+}
+
+func saveResume(opts *config, steps []string, attempts int) {
+	if buf, err := goutil.JSON.Stringify(map[string]interface{}{
+		"config":   opts.values,
+		"steps":    steps,
+		"attempts": attempts,
+	}); err == nil {
+		os.MkdirAll(stateDir, 0700)
+		os.WriteFile(stateDir+"/resume.json", buf, 0600)
+	}
+}
+
+// removeResume removes the resume state and the resume service
+func removeResume() {
+	os.Remove(stateDir + "/resume.json")
+
+	if _, err := os.Stat("/etc/systemd/system/" + resumeUnit); err == nil {
+		bash.Run([]string{`systemctl`, `disable`, resumeUnit}, "", nil)
+		os.Remove("/etc/systemd/system/" + resumeUnit)
+		bash.Run([]string{`systemctl`, `daemon-reload`}, "", nil)
+	}
+}
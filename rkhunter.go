@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+)
+
+const (
+	rkhunterConfLocal = "/etc/rkhunter.conf.local"
+	rkhunterUnit      = "rkhunter-check"
+	rkhunterReport    = "/var/log/rkhunter/report.txt"
+)
+
+// debconf answers, so installing rkhunter does not ask to set up mail
+var rkhunterPreseed = []string{
+	"rkhunter rkhunter/apt_autogen boolean true",
+	"rkhunter rkhunter/cron_daily_run boolean false",
+	"rkhunter rkhunter/cron_db_update boolean true",
+	"postfix postfix/main_mailer_type select No configuration",
+}
+
+// known false positives, only whitelisted if they exist on the system
+var rkhunterWhitelist = map[string][]string{
+	"SCRIPTWHITELIST": {"/usr/bin/egrep", "/usr/bin/fgrep", "/usr/bin/which", "/usr/bin/ldd", "/usr/bin/lwp-request", "/usr/sbin/adduser"},
+	"ALLOWHIDDENDIR":  {"/etc/.java", "/dev/.udev", "/dev/.mdadm"},
+	"ALLOWHIDDENFILE": {"/etc/.updated", "/etc/.pwd.lock", "/usr/share/man/man5/.k5identity.5.gz", "/usr/share/man/man5/.k5login.5.gz"},
+	"ALLOWDEVFILE":    {"/dev/shm/pulse-shm-*", "/dev/shm/PostgreSQL.*", "/dev/shm/sem.*"},
+}
+
+func rkhunterConfig(opts *config) {
+	opts.addValue("rkhunterSchedule", "When should rkhunter check the system for rootkits (default: weekly)?", "weekly")
+}
+
+// writeRkhunterConfig writes rkhunter.conf.local, which overrides the distro rkhunter.conf
+func writeRkhunterConfig() error {
+	pkgmgr := "NONE"
+	if PM == "apt" {
+		pkgmgr = "DPKG"
+	} else if PM == "dnf" {
+		pkgmgr = "RPM"
+	}
+
+	conf := []string{
+		"# generated by Special Modifications",
+		"UPDATE_MIRRORS=1",
+		"MIRRORS_MODE=0",
+		// debian sets this to /bin/false, which rkhunter rejects as a relative path
+		`WEB_CMD=""`,
+		"PKGMGR=" + pkgmgr,
+	}
+
+	for _, key := range []string{"SCRIPTWHITELIST", "ALLOWHIDDENDIR", "ALLOWHIDDENFILE", "ALLOWDEVFILE"} {
+		for _, path := range rkhunterWhitelist[key] {
+			if strings.Contains(path, "*") {
+				conf = append(conf, key+"="+path)
+			} else if _, err := os.Stat(path); err == nil {
+				conf = append(conf, key+"="+path)
+			}
+		}
+	}
+
+	var backup []byte
+	if buf, err := os.ReadFile(rkhunterConfLocal); err == nil {
+		backup = buf
+	}
+
+	if err := os.WriteFile(rkhunterConfLocal, []byte(strings.Join(conf, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if out, err := bash.RunRaw(`rkhunter --config-check --nocolors </dev/null`, "", nil); err != nil {
+		if backup != nil {
+			os.WriteFile(rkhunterConfLocal, backup, 0644)
+		} else {
+			os.Remove(rkhunterConfLocal)
+		}
+		return fmt.Errorf("invalid rkhunter config: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// scheduleRkhunter writes a systemd timer that runs a rootkit check and keeps its warnings as a report
+func scheduleRkhunter(calendar string) error {
+	if out, err := bash.Run([]string{`systemd-analyze`, `calendar`, calendar}, "", nil); err != nil {
+		return fmt.Errorf("invalid schedule %q: %s", calendar, strings.TrimSpace(string(out)))
+	}
+
+	rkhunter := "/usr/bin/rkhunter"
+	if out, err := bash.Run([]string{`which`, `rkhunter`}, "", nil); err == nil && len(out) != 0 {
+		rkhunter = strings.TrimSpace(string(out))
+	}
+
+	service := `[Unit]
+Description=rkhunter rootkit check
+After=network-online.target
+
+[Service]
+Type=oneshot
+Nice=15
+IOSchedulingClass=idle
+StandardInput=null
+StandardOutput=file:` + rkhunterReport + `
+TimeoutStartSec=2h
+# rkhunter exits with 1 when it has warnings
+SuccessExitStatus=1
+# file: output does not truncate, clear the previous report first
+ExecStartPre=/usr/bin/truncate -s0 ` + rkhunterReport + `
+ExecStart=` + rkhunter + ` --check --sk --rwo --nocolors
+`
+
+	timer := `[Unit]
+Description=rkhunter rootkit check
+
+[Timer]
+OnCalendar=` + calendar + `
+Persistent=true
+RandomizedDelaySec=30m
+
+[Install]
+WantedBy=timers.target
+`
+
+	os.MkdirAll("/var/log/rkhunter", 0750)
+
+	if err := os.WriteFile("/etc/systemd/system/"+rkhunterUnit+".service", []byte(service), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile("/etc/systemd/system/"+rkhunterUnit+".timer", []byte(timer), 0644); err != nil {
+		return err
+	}
+
+	bash.Run([]string{`systemctl`, `daemon-reload`}, "", nil)
+	if out, err := bash.Run([]string{`systemctl`, `enable`, `--now`, rkhunterUnit + ".timer"}, "", nil); err != nil {
+		return fmt.Errorf("failed to enable timer: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func installRkhunter(opts *config) {
+	if PM == "apt" {
+		if out, err := bash.Run([]string{`which`, `debconf-set-selections`}, "", nil); err == nil && len(out) != 0 {
+			if file, err := os.CreateTemp("", "rkhunter-*.preseed"); err == nil {
+				file.WriteString(strings.Join(rkhunterPreseed, "\n") + "\n")
+				file.Close()
+				bash.Run([]string{`debconf-set-selections`, file.Name()}, "", nil)
+				os.Remove(file.Name())
+			}
+		}
+	}
+
+	installPKG(`rkhunter`)
+
+	// the daily cron job is replaced by the systemd timer
+	if _, err := os.Stat("/etc/default/rkhunter"); err == nil {
+		bash.RunRaw(`sed -r -i 's/^CRON_DAILY_RUN=.*$/CRON_DAILY_RUN="false"/m' /etc/default/rkhunter`, "", nil)
+	}
+
+	if err := writeRkhunterConfig(); err != nil {
+		fmt.Println(err)
+	}
+
+	// rkhunter can wait for input, or hang on a slow mirror
+	bash.RunRaw(`timeout 10m rkhunter --update --nocolors </dev/null`, "", nil, true)
+	bash.RunRaw(`timeout 30m rkhunter --propupd --nocolors </dev/null`, "", nil, true)
+
+	if err := scheduleRkhunter(opts.value("rkhunterSchedule")); err != nil {
+		fmt.Println("Failed to schedule rkhunter:", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+
+	bash "github.com/tkdeng/gobash"
+)
+
+type runStep struct {
+	name   string
+	config func(opts *config)
+	run    func(opts *config) bool // returns true if a reboot is needed
+
+	// afterReboot steps wait for the system to boot into the new kernel
+	afterReboot bool
+}
+
+var runAllSteps = []runStep{
+	{name: "core", config: installConfig, run: installCore},
+	{name: "apps", run: func(opts *config) bool {
+		//todo: install apps
+		fmt.Println("Install Apps: Not yet implemented")
+		return false
+	}},
+	{name: "theme", run: func(opts *config) bool {
+		//todo: install theme (also detect desktop environment for different themes)
+		fmt.Println("Install Theme: Not yet implemented")
+		return false
+	}},
+	{name: "kernel", config: kernelConfig, run: installKernel},
+	{name: "kernel-cleanup", afterReboot: true, run: func(opts *config) bool {
+		cleanKernels(opts)
+
+		if latest := variantKernel(opts); latest != runningKernel() {
+			fmt.Println("Warning: Not running the newest kernel " + latest)
+		}
+		return false
+	}},
+	{name: "boot", config: bootConfig, afterReboot: true, run: func(opts *config) bool {
+		optimizeBoot(opts)
+		return false
+	}},
+}
+
+func runAll() {
+	opts := newConfig()
+
+	//* get all config options before running anything
+	for _, step := range runAllSteps {
+		if step.config != nil {
+			step.config(opts)
+		}
+	}
+
+	rebootSteps := []string{}
+	pending := []string{}
+
+	for _, step := range runAllSteps {
+		if step.afterReboot {
+			pending = append(pending, step.name)
+			continue
+		}
+
+		fmt.Println("")
+		if step.run(opts) {
+			rebootSteps = append(rebootSteps, step.name)
+		}
+	}
+
+	if len(rebootSteps) == 0 {
+		runSteps(opts, pending)
+		return
+	}
+
+	scheduleResume(opts, pending)
+
+	fmt.Println("")
+	fmt.Println("A reboot is needed to finish:", rebootSteps)
+
+	if AssumeYes {
+		fmt.Println("Rebooting in 1 minute...")
+		bash.Run([]string{`shutdown`, `-r`, `+1`}, "", nil)
+	} else if bash.InputYN("Would you like to reboot now?", true) {
+		bash.Run([]string{`systemctl`, `reboot`}, "", nil)
+	} else {
+		fmt.Println("The remaining steps will run automatically after the next reboot")
+	}
+}
+
+func runSteps(opts *config, names []string) {
+	for _, name := range names {
+		for _, step := range runAllSteps {
+			if step.name == name {
+				fmt.Println("")
+				step.run(opts)
+				break
+			}
+		}
+	}
+}
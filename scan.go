@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	bash "github.com/tkdeng/gobash"
+)
+
+// number of files passed to clamdscan at a time, between progress updates
+const scanBatchSize = 200
+
+type scanInfection struct {
+	File      string `json:"file"`
+	Detection string `json:"detection"`
+	Moved     string `json:"moved,omitempty"`
+}
+
+type scanReport struct {
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Scanner  string           `json:"scanner"`
+	Paths    []string         `json:"paths"`
+	Scanned  int              `json:"scanned"`
+	Infected []*scanInfection `json:"infected"`
+	Errors   []string         `json:"errors"`
+}
+
+// scanExcludesFor returns the exclusions used by the daily scheduled scan
+func scanExcludesFor() []*regexp.Regexp {
+	excludes := scanExcludes
+	for _, schedule := range loadScanSchedules() {
+		if schedule.Name == "daily" {
+			excludes = schedule.Exclude
+		}
+	}
+
+	res := []*regexp.Regexp{regexp.MustCompile(`^` + regexp.QuoteMeta(quarantinePath()))}
+	for _, exclude := range excludes {
+		if re, err := regexp.Compile(exclude); err == nil {
+			res = append(res, re)
+		}
+	}
+	return res
+}
+
+// scanFiles lists the regular files under the paths, skipping excluded directories
+func scanFiles(paths []string, report *scanReport) []string {
+	excludes := scanExcludesFor()
+	files := []string{}
+
+	for _, root := range paths {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				return nil
+			}
+
+			if d.IsDir() {
+				for _, re := range excludes {
+					if re.MatchString(path) {
+						return filepath.SkipDir
+					}
+				}
+				return nil
+			}
+
+			if d.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+
+	return files
+}
+
+// scanCommand returns the scanner to use, preferring the running clamd daemon
+func scanCommand(move string) []string {
+	daemon, _ := clamavServices()
+
+	if out, err := bash.Run([]string{`which`, `clamdscan`}, "", nil); err == nil && len(out) != 0 && serviceActive(daemon) {
+		args := []string{`clamdscan`, `--fdpass`, `--infected`, `--no-summary`}
+		if conf := readClamdConfig(); conf != nil {
+			args = append(args, `--config-file=`+conf.path)
+		}
+		if move != "" {
+			args = append(args, `--move=`+move)
+		}
+		return args
+	}
+
+	// clamscan lists every file it checks, the lines are used for progress
+	args := []string{`clamscan`, `--no-summary`}
+	if move != "" {
+		args = append(args, `--move=`+move)
+	}
+	return args
+}
+
+func runScan(paths []string, move bool) *scanReport {
+	report := &scanReport{
+		Started:  time.Now(),
+		Paths:    paths,
+		Infected: []*scanInfection{},
+		Errors:   []string{},
+	}
+
+	dest := ""
+	if move {
+		dest = quarantinePath()
+		os.MkdirAll(dest, 0700)
+	}
+
+	cmd := scanCommand(dest)
+	report.Scanner = cmd[0]
+
+	fmt.Println("Finding files...")
+	files := scanFiles(paths, report)
+
+	progressBar := bash.NewProgressBar("Scanning")
+	progressBar.SetSize(max(len(files), 1))
+
+	listFile, err := os.CreateTemp("", "scan-*.list")
+	if err != nil {
+		progressBar.Stop()
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	defer os.Remove(listFile.Name())
+	listFile.Close()
+
+	reFound := regexp.MustCompile(`^(.+): (.+) FOUND$`)
+	reMoved := regexp.MustCompile(`^(.+): moved to '(.+)'$`)
+	reError := regexp.MustCompile(`^(.+): (.+) ERROR$`)
+
+	index := loadQuarantine()
+	index.sync()
+
+	parseLine := func(line string) {
+		if m := reFound.FindStringSubmatch(line); m != nil {
+			report.Infected = append(report.Infected, &scanInfection{File: m[1], Detection: m[2]})
+			fmt.Println("Infected: " + m[1] + " (" + m[2] + ")")
+		} else if m := reMoved.FindStringSubmatch(line); m != nil {
+			for _, infection := range report.Infected {
+				if infection.File == m[1] {
+					infection.Moved = m[2]
+					index.Entries = append(index.Entries, &quarantineEntry{File: m[2], Original: m[1], Detection: infection.Detection, Date: time.Now()})
+				}
+			}
+		} else if m := reError.FindStringSubmatch(line); m != nil {
+			report.Errors = append(report.Errors, line)
+		}
+	}
+
+	if cmd[0] == "clamscan" {
+		// clamscan loads the whole signature database on every run, so it only runs once
+		os.WriteFile(listFile.Name(), []byte(strings.Join(files, "\n")+"\n"), 0600)
+
+		scan := exec.Command(cmd[0], append(cmd[1:], `--file-list=`+listFile.Name())...)
+		stderr := &bytes.Buffer{}
+		scan.Stderr = stderr
+
+		stdout, err := scan.StdoutPipe()
+		if err == nil {
+			err = scan.Start()
+		}
+
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		} else {
+			// each file gets an OK, FOUND or ERROR line as it is scanned
+			lines := bufio.NewScanner(stdout)
+			lines.Buffer(make([]byte, 64*1024), 1024*1024)
+
+			dir := ""
+			for lines.Scan() {
+				line := strings.TrimSpace(lines.Text())
+				parseLine(line)
+
+				file, _, ok := strings.Cut(line, ": ")
+				if !ok || reMoved.MatchString(line) {
+					continue
+				}
+
+				if d := filepath.Dir(file); d != dir {
+					dir = d
+					progressBar.Msg("Scanning " + dir)
+				}
+
+				if report.Scanned < len(files) {
+					report.Scanned++
+					progressBar.Step()
+				}
+			}
+
+			// drain the output if a line was too long, so clamscan does not block
+			io.Copy(io.Discard, stdout)
+
+			// clamscan exits with 1 when a virus is found, so the output is checked instead
+			scan.Wait()
+
+			for _, line := range strings.Split(stderr.String(), "\n") {
+				parseLine(strings.TrimSpace(line))
+			}
+		}
+	} else {
+		for i := 0; i < len(files); i += scanBatchSize {
+			batch := files[i:min(i+scanBatchSize, len(files))]
+			progressBar.Msg("Scanning " + filepath.Dir(batch[0]))
+
+			os.WriteFile(listFile.Name(), []byte(strings.Join(batch, "\n")+"\n"), 0600)
+
+			// clamdscan exits with 1 when a virus is found, so the output is checked instead
+			out, _ := bash.Run(append(cmd, `--file-list=`+listFile.Name()), "", nil)
+
+			for _, line := range strings.Split(string(out), "\n") {
+				parseLine(strings.TrimSpace(line))
+			}
+
+			report.Scanned += len(batch)
+			progressBar.Step(len(batch))
+		}
+	}
+
+	progressBar.Stop()
+	index.save()
+
+	report.Finished = time.Now()
+	return report
+}
+
+func (report *scanReport) String() string {
+	lines := []string{
+		"Virus Scan Report",
+		"Started:  " + report.Started.Format("2006-01-02 15:04:05"),
+		"Finished: " + report.Finished.Format("2006-01-02 15:04:05"),
+		"Scanner:  " + report.Scanner,
+		"Paths:    " + strings.Join(report.Paths, " "),
+		"Scanned:  " + strconv.Itoa(report.Scanned) + " files",
+		"Infected: " + strconv.Itoa(len(report.Infected)) + " files",
+		"Errors:   " + strconv.Itoa(len(report.Errors)),
+	}
+
+	for _, infection := range report.Infected {
+		line := "  " + infection.File + ": " + infection.Detection
+		if infection.Moved != "" {
+			line += " (moved to " + infection.Moved + ")"
+		}
+		lines = append(lines, line)
+	}
+
+	for _, err := range report.Errors {
+		lines = append(lines, "  error: "+err)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// save writes the json and human readable reports, and returns the path without an extension
+func (report *scanReport) save(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "scan-report-"+report.Started.Format("20060102-150405"))
+
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".json", buf, 0640); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".txt", []byte(report.String()), 0640); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// scanCLI handles the `scan [paths...] [--move] [--report=dir]` command
+func scanCLI() {
+	paths := []string{}
+	for i := 1; cliArgs[strconv.Itoa(i)] != ""; i++ {
+		if path, err := filepath.Abs(cliArgs[strconv.Itoa(i)]); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		paths = homeDirs()
+	}
+
+	report := runScan(paths, cliArgs["move"] == "true")
+	fmt.Print(report.String())
+
+	dir := cliArgs["report"]
+	if dir == "" {
+		dir = "/var/log/clamav"
+	}
+
+	if path, err := report.save(dir); err == nil {
+		fmt.Println("Report saved to: " + path + ".{json,txt}")
+	} else {
+		fmt.Println("Failed to save report:", err)
+	}
+
+	if len(report.Infected) != 0 {
+		os.Exit(1)
+	}
+}
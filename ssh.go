@@ -0,0 +1,516 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+// sshPorts returns the ports sshd is listening on, including the port of the current ssh session
+func sshPorts() []string {
+	ports := []string{}
+
+	if out, err := bash.Run([]string{`sshd`, `-T`}, "", nil); err == nil {
+		for _, line := range strings.Split(string(out), "\n") {
+			if key, val, ok := strings.Cut(strings.TrimSpace(line), " "); ok && key == "port" && !goutil.Contains(ports, val) {
+				ports = append(ports, val)
+			}
+		}
+	}
+
+	// SSH_CONNECTION="client_ip client_port server_ip server_port"
+	if conn := strings.Fields(os.Getenv("SSH_CONNECTION")); len(conn) == 4 && !goutil.Contains(ports, conn[3]) {
+		ports = append(ports, conn[3])
+	}
+
+	if len(ports) == 0 {
+		ports = append(ports, "22")
+	}
+
+	return ports
+}
+
+// guardSSH allows the active sshd ports through the firewall, so remote sessions are not locked out
+func guardSSH(policy *firewallPolicy) {
+	for _, port := range sshPorts() {
+		if !policy.allows(port, "tcp") {
+			policy.rules = append(policy.rules, firewallRule{name: "ssh", port: port, proto: "tcp"})
+		}
+	}
+}
+
+const firewallRollbackUnit = "special-modifications-firewall-rollback"
+const firewallBackupDir = stateDir + "/firewall-backup"
+
+func firewallRollbackTimeout(opts *config) int {
+	timeout, err := strconv.Atoi(opts.value("firewallRollback"))
+	if err != nil || timeout < 1 {
+		timeout = 60
+	}
+	return timeout
+}
+
+// firewallConfigPaths are saved before the firewall is changed, so the previous ruleset can be restored
+func firewallConfigPaths() []string {
+	return []string{"/etc/ufw", "/etc/default/ufw", "/etc/firewalld", nftablesConf(), nftablesRuleset}
+}
+
+// snapshotFirewall saves the firewall config and the active firewalls before name is set up
+func snapshotFirewall(name string) {
+	os.RemoveAll(firewallBackupDir)
+	os.MkdirAll(firewallBackupDir, 0700)
+
+	previous := activeFirewalls()
+	if goutil.Contains(previous, "firewalld") {
+		// rules that were only added at runtime would be lost on restore
+		bash.Run([]string{`firewall-cmd`, `--runtime-to-permanent`}, "", nil)
+	}
+
+	for _, path := range firewallConfigPaths() {
+		if _, err := os.Stat(path); err == nil {
+			os.MkdirAll(filepath.Dir(firewallBackupDir+path), 0700)
+			bash.Run([]string{`cp`, `-a`, path, firewallBackupDir + path}, "", nil)
+		}
+	}
+
+	if buf, err := goutil.JSON.Stringify(map[string]interface{}{
+		"firewall": name,
+		"previous": previous,
+	}); err == nil {
+		os.WriteFile(firewallBackupDir+"/state.json", buf, 0600)
+	}
+}
+
+// scheduleFirewallRollback restores the snapshot after a timeout, unless keepFirewall is called first
+//
+// this keeps a remote session from being locked out, even if the prompt can not be answered
+func scheduleFirewallRollback(timeout int) bool {
+	exe, err := selfPath()
+	if err != nil {
+		return false
+	}
+
+	bash.Run([]string{`systemctl`, `stop`, firewallRollbackUnit + ".timer"}, "", nil)
+	_, err = bash.Run([]string{`systemd-run`, `--unit=` + firewallRollbackUnit, `--on-active=` + strconv.Itoa(timeout) + `s`, exe, `firewall-rollback`}, "", nil)
+	return err == nil
+}
+
+// keepFirewall cancels the scheduled rollback
+func keepFirewall() {
+	bash.Run([]string{`systemctl`, `stop`, firewallRollbackUnit + ".timer"}, "", nil)
+	os.RemoveAll(firewallBackupDir)
+}
+
+// confirmFirewall asks a remote user to confirm they still have access after enabling the firewall
+//
+// returns false if the user did not confirm before the timeout
+func confirmFirewall(opts *config) bool {
+	timeout := firewallRollbackTimeout(opts)
+
+	fmt.Printf("\nFirewall enabled. Type 'y' within %d seconds to keep the changes: ", timeout)
+
+	// read directly from the terminal, so the prompt can time out
+	out, _ := bash.RunRaw(`read -r -t `+strconv.Itoa(timeout)+` answer && echo "$answer"`, "", nil)
+	fmt.Println("")
+
+	switch strings.ToLower(strings.TrimSpace(string(out))) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// rollbackFirewall disables the new firewall, and restores the firewalls that were active before from the snapshot
+func rollbackFirewall() {
+	bash.Run([]string{`systemctl`, `stop`, firewallRollbackUnit + ".timer"}, "", nil)
+
+	buf, err := os.ReadFile(firewallBackupDir + "/state.json")
+	if err != nil {
+		fmt.Println("No firewall snapshot to restore")
+		return
+	}
+
+	json, err := goutil.JSON.Parse(buf)
+	if err != nil {
+		fmt.Println("Failed to read firewall snapshot:", err)
+		return
+	}
+
+	fmt.Println("Firewall changes were not confirmed, reverting...")
+
+	if name, ok := json["firewall"].(string); ok {
+		newFirewall(name).disable()
+	}
+
+	for _, path := range firewallConfigPaths() {
+		if _, err := os.Stat(firewallBackupDir + path); err == nil {
+			os.RemoveAll(path)
+			bash.Run([]string{`cp`, `-a`, firewallBackupDir + path, path}, "", nil)
+		}
+	}
+
+	if list, ok := json["previous"].([]interface{}); ok {
+		for _, val := range list {
+			if prev, ok := val.(string); ok {
+				newFirewall(prev).enable()
+			}
+		}
+	}
+
+	os.RemoveAll(firewallBackupDir)
+}
+
+const sshdConfigPath = "/etc/ssh/sshd_config"
+const sshdDropInPath = "/etc/ssh/sshd_config.d/00-special-modifications.conf"
+
+// sshdHardening is applied when the ssh server is kept reachable
+var sshdHardening = [][2]string{
+	{"PermitRootLogin", "no"},
+	{"PubkeyAuthentication", "yes"},
+	{"PasswordAuthentication", "no"},
+	{"KbdInteractiveAuthentication", "no"},
+	{"PermitEmptyPasswords", "no"},
+	{"MaxAuthTries", "3"},
+	{"LoginGraceTime", "30"},
+	{"X11Forwarding", "no"},
+}
+
+// sshdAlgorithms are filtered by what the installed openssh supports before they are applied
+var sshdAlgorithms = []struct {
+	key   string
+	query string
+	algos []string
+}{
+	{"Ciphers", "cipher", []string{"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr"}},
+	{"MACs", "mac", []string{"hmac-sha2-512-etm@openssh.com", "hmac-sha2-256-etm@openssh.com", "umac-128-etm@openssh.com"}},
+	{"KexAlgorithms", "kex", []string{"sntrup761x25519-sha512@openssh.com", "curve25519-sha256", "curve25519-sha256@libssh.org", "diffie-hellman-group16-sha512", "diffie-hellman-group18-sha512"}},
+}
+
+type sshdConfig struct {
+	path  string
+	lines []string
+}
+
+func readSSHDConfig(path string) *sshdConfig {
+	conf := &sshdConfig{path: path, lines: []string{}}
+	if buf, err := os.ReadFile(path); err == nil {
+		conf.lines = strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	}
+	return conf
+}
+
+// parse splits a config line into its key and value, ignoring comments
+func (conf *sshdConfig) parse(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+
+	key, val, _ := strings.Cut(strings.ReplaceAll(line, "\t", " "), " ")
+	return strings.ToLower(key), strings.TrimSpace(val)
+}
+
+// get returns the global value of a key (sshd uses the first value it finds)
+func (conf *sshdConfig) get(key string) string {
+	for _, line := range conf.lines {
+		k, v := conf.parse(line)
+		if k == "match" {
+			break
+		} else if k == strings.ToLower(key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// set replaces the global value of a key, or adds it before the first Match block
+func (conf *sshdConfig) set(key string, val string) {
+	match := len(conf.lines)
+
+	for i, line := range conf.lines {
+		k, _ := conf.parse(line)
+		if k == "match" {
+			match = i
+			break
+		} else if k == strings.ToLower(key) {
+			conf.lines[i] = key + " " + val
+			return
+		}
+	}
+
+	conf.lines = append(conf.lines[:match], append([]string{key + " " + val}, conf.lines[match:]...)...)
+}
+
+// includes returns true if the config includes the drop-in directory
+func (conf *sshdConfig) includes(dir string) bool {
+	for _, line := range conf.lines {
+		if k, v := conf.parse(line); k == "include" && strings.HasPrefix(v, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the config, keeping the mode of the file (debian ships 0644), or of the main config for a new drop-in
+func (conf *sshdConfig) save() error {
+	mode := os.FileMode(0600)
+	if stat, err := os.Stat(conf.path); err == nil {
+		mode = stat.Mode().Perm()
+	} else if stat, err := os.Stat(sshdConfigPath); err == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	if err := os.WriteFile(conf.path, []byte(strings.Join(conf.lines, "\n")+"\n"), mode); err != nil {
+		return err
+	}
+	return os.Chmod(conf.path, mode)
+}
+
+// sshService returns the name of the ssh server service (debian uses "ssh")
+func sshService() string {
+	if out, err := bash.Run([]string{`systemctl`, `list-unit-files`, `ssh.service`}, "", nil); err == nil && strings.Contains(string(out), "ssh.service") {
+		return "ssh"
+	}
+	return "sshd"
+}
+
+// setupFirewall applies and enables the policy, prepare runs first (example: installing or resetting the firewall)
+//
+// over ssh, the firewall is snapshotted and a rollback is scheduled before anything changes,
+// and the changes are only kept once they are confirmed
+func setupFirewall(opts *config, name string, policy *firewallPolicy, prepare func(fw firewall)) {
+	fw := newFirewall(name)
+
+	// the prompt times out first, so it does not race the scheduled rollback
+	rollbackTimeout := firewallRollbackTimeout(opts)
+	if !AssumeYes {
+		rollbackTimeout += 30
+	}
+
+	// an ssh allow rule has to be in place before any firewall is enabled,
+	// and the rollback is armed before anything changes
+	rollback := false
+	if SSHClient {
+		snapshotFirewall(name)
+		rollback = scheduleFirewallRollback(rollbackTimeout)
+		guardSSH(policy)
+	}
+
+	prepare(fw)
+
+	if err := fw.apply(policy); err != nil {
+		// keep the current firewall, enabling a half applied policy could lock out ssh
+		fmt.Println("Failed to apply firewall rules, keeping the current firewall:", err)
+		if SSHClient {
+			rollbackFirewall()
+		}
+		return
+	}
+
+	// restart the countdown, so installing packages does not use up the time to confirm
+	if rollback {
+		rollback = scheduleFirewallRollback(rollbackTimeout)
+	}
+	fw.enable()
+
+	if !SSHClient {
+		return
+	}
+
+	if !AssumeYes {
+		if confirmFirewall(opts) {
+			keepFirewall()
+		} else {
+			rollbackFirewall()
+		}
+	} else if rollback {
+		fmt.Printf("Firewall enabled. Run `%s firewall-confirm` from a new SSH session within %d seconds to keep the changes\n", os.Args[0], firewallRollbackTimeout(opts))
+	} else {
+		fmt.Println("Warning: Failed to schedule the firewall rollback, check that SSH is still reachable")
+	}
+}
+
+// hasAuthorizedKeys returns true if the user running this program can log in with an ssh key
+//
+// other accounts are not checked, their keys do not keep this session from being locked out
+func hasAuthorizedKeys() bool {
+	user := os.Getenv("SUDO_USER")
+	if user == "" {
+		user = "root"
+	}
+
+	out, err := bash.Run([]string{`getent`, `passwd`, user}, "", nil)
+	passwd := strings.Split(strings.TrimSpace(string(out)), ":")
+	if err != nil || len(passwd) < 7 {
+		return false
+	}
+	uid, home := passwd[2], passwd[5]
+
+	// older openssh versions need every field of the connection spec
+	addr := "127.0.0.1"
+	if conn := strings.Fields(os.Getenv("SSH_CONNECTION")); len(conn) == 4 {
+		addr = conn[0]
+	}
+
+	files := []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+	if out, err := bash.Run([]string{`sshd`, `-T`, `-C`, `user=` + user + `,host=localhost,addr=` + addr}, "", nil); err == nil {
+		for _, line := range strings.Split(string(out), "\n") {
+			if val, ok := strings.CutPrefix(strings.TrimSpace(line), "authorizedkeysfile "); ok {
+				files = strings.Fields(val)
+				break
+			}
+		}
+	}
+
+	for _, file := range files {
+		if file == "none" {
+			continue
+		}
+
+		file = strings.NewReplacer("%%", "%", "%h", home, "%u", user, "%U", uid).Replace(file)
+		if !filepath.IsAbs(file) {
+			file = home + "/" + file
+		}
+
+		if stat, err := os.Stat(file); err == nil && stat.Size() != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func disableSSH() {
+	service := sshService()
+	bash.Run([]string{`systemctl`, `disable`, `--now`, service + `.service`, service + `.socket`}, "", nil)
+
+	conf := readSSHDConfig(sshdConfigPath)
+	if len(conf.lines) != 0 {
+		conf.set("PermitRootLogin", "no")
+		conf.set("PasswordAuthentication", "no")
+		conf.save()
+	}
+}
+
+// hardenSSH applies the hardened sshd settings, and only reloads sshd if the config is valid
+func hardenSSH() {
+	base := readSSHDConfig(sshdConfigPath)
+	if len(base.lines) == 0 {
+		fmt.Println("No sshd config found, skipping SSH hardening")
+		return
+	}
+
+	settings := [][2]string{}
+
+	keyAuth := hasAuthorizedKeys()
+	for _, opt := range sshdHardening {
+		if !keyAuth && (opt[0] == "PasswordAuthentication" || opt[0] == "KbdInteractiveAuthentication") {
+			continue
+		}
+
+		if opt[0] == "PermitRootLogin" && SSHClient && os.Getenv("SUDO_USER") == "" {
+			// logged in as root, keep key access for the current session
+			settings = append(settings, [2]string{opt[0], "prohibit-password"})
+			continue
+		}
+
+		settings = append(settings, opt)
+	}
+
+	if !keyAuth {
+		fmt.Println("No authorized ssh keys found for the current user, keeping password authentication enabled")
+	}
+
+	for _, opt := range sshdAlgorithms {
+		out, err := bash.Run([]string{`ssh`, `-Q`, opt.query}, "", nil)
+		if err != nil {
+			continue
+		}
+
+		supported := strings.Fields(string(out))
+		algos := []string{}
+		for _, algo := range opt.algos {
+			if goutil.Contains(supported, algo) {
+				algos = append(algos, algo)
+			}
+		}
+
+		if len(algos) != 0 {
+			settings = append(settings, [2]string{opt.key, strings.Join(algos, ",")})
+		}
+	}
+
+	// settings found first take priority, so prefer a drop-in when the main config includes one
+	if !base.includes("/etc/ssh/sshd_config.d") {
+		applySSHD(base, settings)
+		return
+	}
+
+	os.MkdirAll("/etc/ssh/sshd_config.d", 0755)
+	if !applySSHD(&sshdConfig{path: sshdDropInPath, lines: []string{"# generated by Special Modifications"}}, settings) {
+		return
+	}
+
+	// the drop-in is ignored when the settings come before the include, so they are set in the main config instead
+	if overridden := sshdOverridden(settings); len(overridden) != 0 {
+		fmt.Println("The drop-in is overridden by " + sshdConfigPath + " (" + strings.Join(overridden, ", ") + "), editing it instead")
+		applySSHD(base, settings)
+	}
+
+	if overridden := sshdOverridden(settings); len(overridden) != 0 {
+		fmt.Println("Warning: sshd is still using other values for " + strings.Join(overridden, ", "))
+	}
+}
+
+// applySSHD writes the settings to an sshd config, and reloads sshd if the config is valid
+func applySSHD(conf *sshdConfig, settings [][2]string) bool {
+	backup := readSSHDConfig(conf.path)
+
+	for _, opt := range settings {
+		conf.set(opt[0], opt[1])
+	}
+	conf.save()
+
+	if out, err := bash.Run([]string{`sshd`, `-t`}, "", nil); err != nil {
+		fmt.Println("Invalid sshd config, reverting SSH hardening:", strings.TrimSpace(string(out)))
+		if len(backup.lines) == 0 {
+			os.Remove(conf.path)
+		} else {
+			backup.save()
+		}
+		return false
+	}
+
+	bash.Run([]string{`systemctl`, `reload`, sshService()}, "", nil)
+	return true
+}
+
+// sshdOverridden returns the settings that sshd does not use, according to its effective config
+func sshdOverridden(settings [][2]string) []string {
+	out, err := bash.Run([]string{`sshd`, `-T`}, "", nil)
+	if err != nil {
+		return []string{}
+	}
+
+	effective := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if key, val, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			// older openssh versions print the old name of prohibit-password
+			effective[key] = strings.Replace(val, "without-password", "prohibit-password", 1)
+		}
+	}
+
+	overridden := []string{}
+	for _, opt := range settings {
+		// older openssh versions may not list every key
+		if val, ok := effective[strings.ToLower(opt[0])]; ok && !strings.EqualFold(val, opt[1]) {
+			overridden = append(overridden, opt[0])
+		}
+	}
+	return overridden
+}
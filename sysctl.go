@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+// sysctlDropInPath is installed from assets/fs, and rewritten with the selected groups
+const sysctlDropInPath = "/etc/sysctl.d/90-special-modifications.conf"
+
+// groups marked with "## name" in the drop-in
+var sysctlGroups = []string{"kernel", "network", "bpf", "ptrace"}
+
+type sysctlSetting struct {
+	key string
+	val string
+}
+
+func sysctlConfig(opts *config) {
+	if opts.addBool("sysctlHardening", "Would you like to harden the kernel and network settings (sysctl)?", true) {
+		opts.addValue("sysctlGroups", "Which sysctl hardening groups should be applied ("+strings.Join(sysctlGroups, " ")+") (default: all)?", strings.Join(sysctlGroups, " "))
+	}
+}
+
+// sysctlConf returns the drop-in with the unselected groups and unsupported keys commented out,
+// and the settings that are left active
+func sysctlConf(opts *config) (string, []sysctlSetting, []string) {
+	buf, err := assetFS.ReadFile("assets/fs" + sysctlDropInPath)
+	if err != nil {
+		return "", nil, nil
+	}
+
+	groups := []string{}
+	if opts.bool("sysctlHardening") {
+		groups = strings.Fields(strings.ReplaceAll(opts.value("sysctlGroups"), ",", " "))
+	}
+
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	active := []sysctlSetting{}
+	unsupported := []string{}
+
+	group := ""
+	for i, line := range lines {
+		if name, ok := strings.CutPrefix(line, "## "); ok {
+			group = strings.TrimSpace(name)
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+
+		if !goutil.Contains(groups, group) {
+			lines[i] = "# " + line
+		} else if _, err := os.Stat("/proc/sys/" + strings.ReplaceAll(key, ".", "/")); err != nil {
+			lines[i] = "# not supported: " + line
+			unsupported = append(unsupported, key)
+		} else {
+			active = append(active, sysctlSetting{key: key, val: val})
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n", active, unsupported
+}
+
+// hardenSysctl writes the drop-in, applies it, and reports the keys the kernel did not accept
+func hardenSysctl(opts *config) {
+	conf, active, unsupported := sysctlConf(opts)
+	if conf == "" {
+		fmt.Println("Missing sysctl drop-in asset, skipping kernel hardening")
+		return
+	}
+
+	os.MkdirAll("/etc/sysctl.d", 0755)
+	if err := os.WriteFile(sysctlDropInPath, []byte(conf), 0644); err != nil {
+		fmt.Println("Failed to write sysctl drop-in:", err)
+		return
+	}
+
+	out, _ := bash.Run([]string{`sysctl`, `--system`}, "", nil)
+
+	// a later drop-in can also override a key, so check what the kernel actually uses
+	rejected := []string{}
+	for _, setting := range active {
+		current, err := bash.Run([]string{`sysctl`, `-n`, setting.key}, "", nil)
+		if err == nil && strings.Join(strings.Fields(string(current)), " ") == setting.val {
+			continue
+		}
+
+		reason := "value is " + strings.TrimSpace(string(current))
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.Contains(line, setting.key) && strings.HasPrefix(line, "sysctl:") {
+				reason = strings.TrimSpace(line)
+				break
+			}
+		}
+		rejected = append(rejected, setting.key+" ("+reason+")")
+	}
+
+	fmt.Println("Sysctl: applied " + strconv.Itoa(len(active)-len(rejected)) + " settings")
+	for _, key := range unsupported {
+		fmt.Println("Sysctl: not supported by the running kernel: " + key)
+	}
+	for _, key := range rejected {
+		fmt.Println("Sysctl: rejected: " + key)
+	}
+}
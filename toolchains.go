@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/goutil"
+)
+
+type toolchain struct {
+	name    string
+	label   string
+	config  func(opts *config)
+	install func(opts *config) error
+	verify  func(opts *config) (string, error) // returns the installed version
+}
+
+var toolchains = []toolchain{
+	{name: "python", label: "Python", install: installPython, verify: func(opts *config) (string, error) {
+		return versionOf([]string{`python3`, `--version`}, []string{`pipx`, `--version`})
+	}},
+	{name: "c", label: "C/C++", install: func(opts *config) error {
+		if PM == "apt" {
+			installPKG(`build-essential`)
+		} else if PM == "dnf" {
+			installPKG(`gcc`, `gcc-c++`, `make`)
+		}
+		return nil
+	}, verify: func(opts *config) (string, error) {
+		return versionOf([]string{`gcc`, `--version`}, []string{`g++`, `--version`})
+	}},
+	{name: "java", label: "Java", config: func(opts *config) {
+		opts.addValue("javaVersions", "Which JDK versions would you like to install, separated by spaces (example: 8 17 21 latest) (default: 21)?", "21")
+	}, install: installJava, verify: func(opts *config) (string, error) {
+		return versionOf([]string{`javac`, `-version`})
+	}},
+	{name: "node", label: "Node.js", config: func(opts *config) {
+		opts.addSelect("nodeSource", "Where should Node.js be installed from (nodesource has the latest LTS)?", "distro", "nodesource")
+	}, install: installNode, verify: func(opts *config) (string, error) {
+		return versionOf([]string{`node`, `--version`}, []string{`npm`, `--version`})
+	}},
+	{name: "go", label: "Go", config: func(opts *config) {
+		opts.addSelect("goSource", "Where should Go be installed from (upstream has the latest release)?", "distro", "upstream")
+	}, install: installGo, verify: func(opts *config) (string, error) {
+		if opts.value("goSource") == "upstream" {
+			return versionOf([]string{`/usr/local/go/bin/go`, `version`})
+		}
+		return versionOf([]string{`go`, `version`})
+	}},
+	{name: "rust", label: "Rust", install: installRust, verify: func(opts *config) (string, error) {
+		out, err := runAsDevUser(`"$HOME/.cargo/bin/rustc" --version`)
+		return firstLine(out), err
+	}},
+	{name: "docker", label: "Docker", install: installDocker, verify: func(opts *config) (string, error) {
+		return versionOf([]string{`docker`, `--version`})
+	}},
+}
+
+func toolchainsConfig(opts *config) {
+	names := []string{}
+	for _, tc := range toolchains {
+		names = append(names, tc.name)
+	}
+
+	selected := opts.addValue("devToolchains", "Which programming languages would you like to install ("+strings.Join(names, " ")+", or none) (default: python c java node go docker)?", "python c java node go docker")
+
+	for _, name := range strings.Fields(strings.ReplaceAll(selected, ",", " ")) {
+		if name != "none" && !goutil.Contains(names, name) {
+			fmt.Println("Unknown programming language, skipping: " + name + " (choose from: " + strings.Join(names, " ") + ")")
+		}
+	}
+
+	for _, tc := range selectedToolchains(opts) {
+		if tc.config != nil {
+			tc.config(opts)
+		}
+	}
+}
+
+func selectedToolchains(opts *config) []toolchain {
+	names := strings.Fields(strings.ReplaceAll(opts.value("devToolchains"), ",", " "))
+
+	selected := []toolchain{}
+	for _, tc := range toolchains {
+		if goutil.Contains(names, tc.name) {
+			selected = append(selected, tc)
+		}
+	}
+	return selected
+}
+
+// installToolchains installs and verifies the selected languages, one progress step each
+func installToolchains(opts *config, progressBar *bash.ProgressBar) {
+	for _, tc := range selectedToolchains(opts) {
+		progressBar.Msg("Installing " + tc.label)
+
+		if err := tc.install(opts); err != nil {
+			fmt.Println("Failed to install "+tc.label+":", err)
+		}
+
+		if version, err := tc.verify(opts); err != nil {
+			fmt.Println("Failed to verify "+tc.label+":", err)
+		} else {
+			fmt.Println("Installed " + tc.label + ": " + version)
+		}
+
+		progressBar.Step()
+	}
+}
+
+// versionOf runs each version command, and joins the first line of their output
+func versionOf(cmds ...[]string) (string, error) {
+	versions := []string{}
+	for _, cmd := range cmds {
+		out, err := bash.Run(cmd, "", nil)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", cmd[0], firstLine(out))
+		}
+		versions = append(versions, firstLine(out))
+	}
+	return strings.Join(versions, ", "), nil
+}
+
+func firstLine(out []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(line)
+}
+
+// runAsDevUser runs a command as the user that ran sudo, so user level tools end up in their home directory
+func runAsDevUser(cmd string) ([]byte, error) {
+	if user := os.Getenv("SUDO_USER"); user != "" && user != "root" {
+		return bash.Run([]string{`runuser`, `-u`, user, `--`, `bash`, `-lc`, cmd}, "", nil)
+	}
+	return bash.RunRaw(cmd, "", nil)
+}
+
+func installPython(opts *config) error {
+	if PM == "apt" {
+		installPKG(`python3`, `python3-pip`, `python3-venv`, `pipx`)
+	} else if PM == "dnf" {
+		installPKG(`python3`, `python3-pip`, `pipx`)
+	}
+
+	if out, err := runAsDevUser(`pipx ensurepath`); err != nil {
+		return fmt.Errorf("pipx ensurepath: %s", firstLine(out))
+	}
+	return nil
+}
+
+func installJava(opts *config) error {
+	missing := []string{}
+
+	for _, version := range strings.Fields(opts.value("javaVersions")) {
+		var pkg string
+		switch {
+		case PM == "apt" && version == "latest":
+			pkg = "default-jdk"
+		case PM == "apt":
+			pkg = "openjdk-" + version + "-jdk"
+		case version == "8":
+			pkg = "java-1.8.0-openjdk-devel"
+		default:
+			pkg = "java-" + version + "-openjdk-devel"
+		}
+
+		var err error
+		if PM == "apt" {
+			_, err = bash.Run([]string{`apt-cache`, `show`, pkg}, "", nil)
+		} else {
+			_, err = bash.Run([]string{`dnf`, `-q`, `info`, pkg}, "", nil)
+		}
+
+		if err != nil {
+			missing = append(missing, version)
+			continue
+		}
+		installPKG(pkg)
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("JDK versions not available from the distro: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func installNode(opts *config) error {
+	if opts.value("nodeSource") == "nodesource" {
+		installPKG(`curl`)
+
+		setup := `curl -fsSL https://deb.nodesource.com/setup_lts.x | bash -`
+		if PM == "dnf" {
+			setup = `curl -fsSL https://rpm.nodesource.com/setup_lts.x | bash -`
+		}
+
+		if out, err := bash.RunRaw(setup, "", nil); err != nil {
+			return fmt.Errorf("nodesource setup failed: %s", firstLine(out))
+		}
+
+		// the nodesource package includes npm
+		installPKG(`nodejs`)
+		return nil
+	}
+
+	if PM == "dnf" {
+		installPKG(`nodejs`, `npm`)
+	} else if PM == "apt" {
+		installPKG(`nodejs`)
+		if hasNalaPM {
+			bash.Run([]string{`nala`, `install`, `-y`, `--no-install-recommends`, `npm`}, "", nil)
+		} else {
+			bash.Run([]string{`apt`, `-y`, `--no-install-recommends`, `install`, `npm`}, "", nil)
+		}
+	}
+	return nil
+}
+
+func installGo(opts *config) error {
+	if opts.value("goSource") != "upstream" {
+		installPKG(`golang`)
+		return nil
+	}
+
+	installPKG(`curl`)
+
+	out, err := bash.Run([]string{`curl`, `-fsSL`, `https://go.dev/dl/?mode=json`}, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to get go releases: %s", firstLine(out))
+	}
+
+	releases := []struct {
+		Version string `json:"version"`
+		Stable  bool   `json:"stable"`
+		Files   []struct {
+			Filename string `json:"filename"`
+			OS       string `json:"os"`
+			Arch     string `json:"arch"`
+			Sha256   string `json:"sha256"`
+			Kind     string `json:"kind"`
+		} `json:"files"`
+	}{}
+	if err := json.Unmarshal(out, &releases); err != nil {
+		return err
+	}
+
+	// go.dev names 32 bit arm after the oldest arm version it supports
+	arch := runtime.GOARCH
+	if arch == "arm" {
+		arch = "armv6l"
+	}
+
+	filename, sum := "", ""
+	for _, release := range releases {
+		if !release.Stable {
+			continue
+		}
+		for _, file := range release.Files {
+			if file.OS == "linux" && file.Arch == arch && file.Kind == "archive" {
+				filename, sum = file.Filename, file.Sha256
+				break
+			}
+		}
+		if filename != "" {
+			break
+		}
+	}
+	if filename == "" {
+		return fmt.Errorf("no go release found for linux/%s", arch)
+	}
+
+	tmp, err := os.CreateTemp("", "go-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if out, err := bash.Run([]string{`curl`, `-fsSL`, `-o`, tmp.Name(), `https://go.dev/dl/` + filename}, "", nil); err != nil {
+		return fmt.Errorf("failed to download %s: %s", filename, firstLine(out))
+	}
+
+	file, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return err
+	} else if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return fmt.Errorf("checksum mismatch for %s", filename)
+	}
+
+	os.RemoveAll("/usr/local/go")
+	if out, err := bash.Run([]string{`tar`, `-C`, `/usr/local`, `-xzf`, tmp.Name()}, "", nil); err != nil {
+		return fmt.Errorf("failed to extract %s: %s", filename, firstLine(out))
+	}
+
+	return os.WriteFile("/etc/profile.d/golang.sh", []byte("export PATH=$PATH:/usr/local/go/bin\n"), 0644)
+}
+
+func installRust(opts *config) error {
+	installPKG(`curl`)
+
+	if _, err := runAsDevUser(`"$HOME/.cargo/bin/rustup" --version`); err == nil {
+		if out, err := runAsDevUser(`"$HOME/.cargo/bin/rustup" update`); err != nil {
+			return fmt.Errorf("rustup update failed: %s", firstLine(out))
+		}
+		return nil
+	}
+
+	if out, err := runAsDevUser(`curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y`); err != nil {
+		return fmt.Errorf("rustup install failed: %s", firstLine(out))
+	}
+	return nil
+}
+
+func installDocker(opts *config) error {
+	if PM == "dnf" {
+		installPKG(`dnf-plugins-core`)
+		bash.Run([]string{`dnf`, `config-manager`, `--add-repo`, `https://download.docker.com/linux/fedora/docker-ce.repo`}, "", nil)
+		installPKG(`docker-ce`, `docker-ce-cli`, `containerd.io`, `docker-buildx-plugin`, `docker-compose-plugin`)
+		installPKG(`docker`)
+		bash.Run([]string{`systemctl`, `enable`, `docker`, `--now`}, "", nil)
+	} else if PM == "apt" {
+		// docker has separate repos for debian and ubuntu
+		distro := osRelease("ID")
+		if distro != "debian" {
+			distro = "ubuntu"
+		}
+
+		installPKG(`ca-certificates`, `curl`)
+		bash.Run([]string{`install`, `-m`, `0755`, `-d`, `/etc/apt/keyrings`}, "", nil)
+		bash.Run([]string{`curl`, `-fsSL`, `https://download.docker.com/linux/` + distro + `/gpg`, `-o`, `/etc/apt/keyrings/docker.asc`}, "", nil)
+		bash.Run([]string{`chmod`, `a+r`, `/etc/apt/keyrings/docker.asc`}, "", nil)
+		bash.RunRaw(`echo "deb [arch=$(dpkg --print-architecture) signed-by=/etc/apt/keyrings/docker.asc] https://download.docker.com/linux/`+distro+` $(. /etc/os-release && echo "${UBUNTU_CODENAME:-$VERSION_CODENAME}") stable" > /etc/apt/sources.list.d/docker.list`, "", nil)
+		bash.Run([]string{`apt`, `-y`, `update`}, "", nil)
+		if hasNalaPM {
+			bash.Run([]string{`nala`, `update`}, "", nil)
+		}
+		installPKG(`docker-ce`, `docker-ce-cli`, `containerd.io`, `docker-buildx-plugin`, `docker-compose-plugin`)
+		bash.Run([]string{`systemctl`, `enable`, `docker`, `--now`}, "", nil)
+	}
+	return nil
+}
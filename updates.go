@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	bash "github.com/tkdeng/gobash"
+	"github.com/tkdeng/regex"
+)
+
+const (
+	dnfAutomaticPath = "/etc/dnf/automatic.conf"
+	aptAutoUpgrades  = "/etc/apt/apt.conf.d/20auto-upgrades"
+	aptUnattended    = "/etc/apt/apt.conf.d/52special-modifications-unattended-upgrades"
+)
+
+// update policies, the first one is the default
+//
+// "security" only installs security updates, "all" installs every update,
+// "download" only downloads updates, and "off" disables automatic updates
+var updatePolicies = []string{"security", "all", "download", "off"}
+
+func updatesConfig(opts *config) {
+	if opts.addSelect("updatePolicy", "Which updates should be installed automatically?", updatePolicies...) == "off" {
+		return
+	}
+
+	opts.addValue("updateReboot", "When should the system reboot after updates that need it, as HH:MM (default: never)?", "never")
+	opts.addValue("updateBlacklist", "Which packages should never be updated automatically, separated by spaces (default: none)?", "")
+}
+
+// updateRebootTime returns the reboot window, or an empty string if the system should never reboot
+func updateRebootTime(opts *config) string {
+	val := opts.value("updateReboot")
+	if regex.Comp(`^([01][0-9]|2[0-3]):[0-5][0-9]$`).Match([]byte(val)) {
+		return val
+	}
+	return ""
+}
+
+// updateTimers returns the systemd timers that run automatic updates
+func updateTimers() []string {
+	if PM == "dnf" {
+		if out, err := bash.Run([]string{`systemctl`, `list-unit-files`, `dnf5-automatic.timer`}, "", nil); err == nil && strings.Contains(string(out), "dnf5-automatic.timer") {
+			return []string{"dnf5-automatic.timer"}
+		}
+		return []string{"dnf-automatic.timer"}
+	}
+	return []string{"apt-daily.timer", "apt-daily-upgrade.timer"}
+}
+
+func configureUpdates(opts *config) {
+	policy := opts.value("updatePolicy")
+	if policy == "" {
+		policy = updatePolicies[0]
+	}
+
+	var err error
+	if PM == "dnf" {
+		err = configureDnfAutomatic(opts, policy)
+	} else if PM == "apt" {
+		err = configureUnattendedUpgrades(opts, policy)
+	}
+
+	if err != nil {
+		fmt.Println("Failed to configure automatic updates:", err)
+		return
+	}
+
+	if policy == "off" {
+		fmt.Println("Automatic updates are off")
+		return
+	}
+
+	for _, timer := range updateTimers() {
+		if !serviceActive(timer) {
+			fmt.Println("Warning: " + timer + " is not active, automatic updates will not run")
+		}
+	}
+}
+
+func configureDnfAutomatic(opts *config, policy string) error {
+	installPKG(`dnf-automatic`)
+
+	timers := updateTimers()
+	if policy == "off" {
+		bash.Run(append([]string{`systemctl`, `disable`, `--now`}, timers...), "", nil)
+		return nil
+	}
+
+	buf, err := os.ReadFile(dnfAutomaticPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := []string{}
+	if len(buf) != 0 {
+		lines = strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	}
+
+	upgradeType := "default"
+	if policy == "security" {
+		upgradeType = "security"
+	}
+
+	apply := "yes"
+	if policy == "download" {
+		apply = "no"
+	}
+
+	reboot := "never"
+	if updateRebootTime(opts) != "" {
+		reboot = "when-needed"
+	}
+
+	lines = setINI(lines, "commands", "upgrade_type", upgradeType)
+	lines = setINI(lines, "commands", "download_updates", "yes")
+	lines = setINI(lines, "commands", "apply_updates", apply)
+	lines = setINI(lines, "commands", "reboot", reboot)
+	if blacklist := strings.Join(strings.Fields(opts.value("updateBlacklist")), " "); blacklist != "" {
+		lines = setINI(lines, "base", "exclude", blacklist)
+	} else {
+		lines = unsetINI(lines, "base", "exclude")
+	}
+
+	if err := os.WriteFile(dnfAutomaticPath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+
+	// dnf-automatic reboots as soon as it has applied the updates, so the timer runs in the reboot window
+	for _, timer := range timers {
+		dropIn := "/etc/systemd/system/" + timer + ".d/special-modifications.conf"
+		if at := updateRebootTime(opts); at != "" {
+			os.MkdirAll("/etc/systemd/system/"+timer+".d", 0755)
+			os.WriteFile(dropIn, []byte("[Timer]\nOnCalendar=\nOnCalendar=*-*-* "+at+":00\nRandomizedDelaySec=0\n"), 0644)
+		} else {
+			os.Remove(dropIn)
+		}
+	}
+
+	bash.Run([]string{`systemctl`, `daemon-reload`}, "", nil)
+	if out, err := bash.Run(append([]string{`systemctl`, `enable`, `--now`}, timers...), "", nil); err != nil {
+		return fmt.Errorf("failed to enable timer: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func configureUnattendedUpgrades(opts *config, policy string) error {
+	installPKG(`unattended-upgrades`)
+
+	periodic := map[string]string{
+		"Update-Package-Lists":          "1",
+		"Download-Upgradeable-Packages": "1",
+		"Unattended-Upgrade":            "1",
+		"AutocleanInterval":             "7",
+	}
+	switch policy {
+	case "download":
+		periodic["Unattended-Upgrade"] = "0"
+	case "off":
+		periodic["Download-Upgradeable-Packages"] = "0"
+		periodic["Unattended-Upgrade"] = "0"
+	}
+
+	auto := "// generated by Special Modifications\n"
+	for _, key := range []string{"Update-Package-Lists", "Download-Upgradeable-Packages", "Unattended-Upgrade", "AutocleanInterval"} {
+		auto += `APT::Periodic::` + key + ` "` + periodic[key] + `";` + "\n"
+	}
+	if err := os.WriteFile(aptAutoUpgrades, []byte(auto), 0644); err != nil {
+		return err
+	}
+
+	// the distro 50unattended-upgrades already allows security updates, "all" only adds to it
+	conf := "// generated by Special Modifications\n"
+	if policy == "security" {
+		// debian's stock config also allows point release updates from the main archive, so the lists are replaced
+		// (the debian and ubuntu security archives are named differently, each pattern only matches one of them)
+		conf += "#clear Unattended-Upgrade::Allowed-Origins;\n" +
+			"#clear Unattended-Upgrade::Origins-Pattern;\n" +
+			"Unattended-Upgrade::Origins-Pattern {\n" +
+			"\t\"origin=${distro_id},codename=${distro_codename}-security,label=${distro_id}-Security\";\n" +
+			"\t\"origin=${distro_id},archive=${distro_codename}-security\";\n" +
+			"\t\"origin=${distro_id}ESMApps,archive=${distro_codename}-apps-security\";\n" +
+			"\t\"origin=${distro_id}ESM,archive=${distro_codename}-infra-security\";\n" +
+			"};\n"
+	} else if policy == "all" {
+		conf += "Unattended-Upgrade::Origins-Pattern {\n" +
+			"\t\"origin=${distro_id},codename=${distro_codename}-updates\";\n" +
+			"};\n"
+	}
+
+	if at := updateRebootTime(opts); at != "" {
+		conf += "Unattended-Upgrade::Automatic-Reboot \"true\";\n" +
+			"Unattended-Upgrade::Automatic-Reboot-Time \"" + at + "\";\n"
+	} else {
+		conf += "Unattended-Upgrade::Automatic-Reboot \"false\";\n"
+	}
+
+	if blacklist := strings.Fields(opts.value("updateBlacklist")); len(blacklist) != 0 {
+		conf += "Unattended-Upgrade::Package-Blacklist {\n"
+		for _, pkg := range blacklist {
+			conf += "\t\"" + pkg + "\";\n"
+		}
+		conf += "};\n"
+	}
+
+	if err := os.WriteFile(aptUnattended, []byte(conf), 0644); err != nil {
+		return err
+	}
+
+	if out, err := bash.Run([]string{`apt-config`, `dump`}, "", nil); err != nil {
+		os.Remove(aptUnattended)
+		return fmt.Errorf("invalid unattended-upgrades config: %s", strings.TrimSpace(string(out)))
+	}
+
+	if policy != "off" {
+		bash.Run(append([]string{`systemctl`, `enable`, `--now`}, updateTimers()...), "", nil)
+	}
+	return nil
+}
+
+// unsetINI comments out a key in a section of an ini file
+func unsetINI(lines []string, section string, key string) []string {
+	reKey := regex.Comp(`^\s*%1\s*=`, key)
+
+	current := ""
+	for i, l := range lines {
+		if trimmed := strings.TrimSpace(l); strings.HasPrefix(trimmed, "[") {
+			current = trimmed
+		} else if current == "["+section+"]" && reKey.Match([]byte(l)) {
+			lines[i] = "# " + l
+		}
+	}
+	return lines
+}
+
+// setINI sets a key in a section of an ini file, adding the section if it is missing
+func setINI(lines []string, section string, key string, val string) []string {
+	line := key + " = " + val
+	reKey := regex.Comp(`^\s*#?\s*%1\s*=`, key)
+
+	start, end := -1, len(lines)
+	for i, l := range lines {
+		if trimmed := strings.TrimSpace(l); strings.HasPrefix(trimmed, "[") {
+			if start != -1 {
+				end = i
+				break
+			} else if trimmed == "["+section+"]" {
+				start = i
+			}
+		}
+	}
+
+	if start == -1 {
+		return append(lines, "", "["+section+"]", line)
+	}
+
+	// prefer an active setting over a commented out one
+	for _, commented := range []bool{false, true} {
+		for i := start + 1; i < end; i++ {
+			if strings.HasPrefix(strings.TrimSpace(lines[i]), "#") == commented && reKey.Match([]byte(lines[i])) {
+				lines[i] = line
+				return lines
+			}
+		}
+	}
+
+	// add to the end of the section, before any blank lines
+	for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return append(lines[:end], append([]string{line}, lines[end:]...)...)
+}